@@ -0,0 +1,265 @@
+// Concurrent doubly-linked list of ints, specialised from List.
+// Storing the values unboxed saves the allocation of an interface{} per
+// inserted value, so it's worth it for performance-sensitive callers.
+
+package concurrent
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// IntElement is an element of an IntList.
+type IntElement struct {
+	// Next and previous pointers in the doubly-linked list of elements.
+	next, prev *IntElement
+
+	// The list to which this element belongs.
+	list *IntList
+
+	// A mutex protects all accesses to the list
+	mutex sync.RWMutex
+
+	// The value stored with this element.
+	Value int
+}
+
+// Next returns the next list element or nil.
+func (e *IntElement) Next() *IntElement {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	if p := e.next; e.list != nil && p != &e.list.tail {
+		return p
+	}
+	return nil
+}
+
+// Prev returns the previous list element or nil.
+func (e *IntElement) Prev() *IntElement {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	if p := e.prev; e.list != nil && p != &e.list.head {
+		return p
+	}
+	return nil
+}
+
+// IntList is a doubly linked list of ints.
+// It works like List, but avoids boxing the values into an interface{}.
+// It only supports the basic operations of List.
+type IntList struct {
+	// Separate sentinels avoid contention between operations at either end
+	head, tail IntElement
+
+	// Fixed size because of atomic access
+	len int64
+}
+
+// lazyInit initializes list l, or clears it if clear is set.
+// Does nothing if l is already initialised.
+func (l *IntList) lazyInit(clear bool) *IntList {
+	if l.Len() != 0 && !clear {
+		return l // Nothing to do, so avoid the locking operations
+	}
+
+	l.head.mutex.Lock()
+	defer l.head.mutex.Unlock()
+	l.tail.mutex.Lock()
+	defer l.tail.mutex.Unlock()
+
+	// double-checked locking
+	if l.Len() == 0 || clear {
+		atomic.StoreInt64(&l.len, 0)
+		l.head.prev = nil
+		l.head.list = l
+		l.head.next = &l.tail
+		l.tail.next = nil
+		l.tail.list = l
+		l.tail.prev = &l.head
+	}
+	return l
+}
+
+// Init initializes or clears list l.
+func (l *IntList) Init() *IntList {
+	return l.lazyInit(true)
+}
+
+// NewIntList returns an initialized list.
+func NewIntList() *IntList {
+	l := new(IntList)
+	return l.lazyInit(false)
+}
+
+// Len returns the number of elements of list l.
+// The complexity is O(1).
+func (l *IntList) Len() int { return int(atomic.LoadInt64(&l.len)) }
+
+// Front returns the first element of list l or nil if the list is empty.
+func (l *IntList) Front() *IntElement {
+	if l.Len() == 0 {
+		return nil
+	}
+
+	l.head.mutex.RLock()
+	defer l.head.mutex.RUnlock()
+	// double-checked locking
+	if l.Len() == 0 {
+		return nil
+	}
+	return l.head.next
+}
+
+// Back returns the last element of list l or nil if the list is empty.
+func (l *IntList) Back() *IntElement {
+	if l.Len() == 0 {
+		return nil
+	}
+
+	l.tail.mutex.RLock()
+	defer l.tail.mutex.RUnlock()
+	// double-checked locking
+	if l.Len() == 0 {
+		return nil
+	}
+	return l.tail.prev
+}
+
+// insertAfter inserts e after at, increments l.len, and returns e.
+// e must not be accessed simultaneously.
+func (l *IntList) insertAfter(e, at *IntElement) (*IntElement, bool) {
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	n := at.next
+	if at.list != l || n == nil {
+		// at is no longer in l, so we can't insert after it
+		return nil, false
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	e.list = l
+	at.next = e
+	e.prev = at
+	e.next = n
+	n.prev = e
+	atomic.AddInt64(&l.len, 1)
+	return e, true
+}
+
+// Returns the predecessor of e in l in a thread safe way.
+// The returned element, if not nil, is locked for writing.
+func (l *IntList) predecessor(e *IntElement) *IntElement {
+	e.mutex.RLock()
+	p := e.prev
+	for ; e.list == l && p != nil; p = e.prev {
+		// We must unlock here to avoid deadlock: Always lock head-to-tail
+		e.mutex.RUnlock()
+		p.mutex.Lock()
+		if p.next == e {
+			return p
+		}
+		// We got a new predecessor before we got the lock, try again
+		p.mutex.Unlock()
+		e.mutex.RLock()
+	}
+	// If the loop terminates without returning, e was removed from l
+	e.mutex.RUnlock()
+	return nil
+}
+
+// insertBefore inserts e before at, increments l.len, and returns e.
+// e must not be accessed simultaneously.
+func (l *IntList) insertBefore(e, at *IntElement) (*IntElement, bool) {
+	p := l.predecessor(at)
+	if p == nil {
+		// at is no longer in l, so we can't insert before it
+		return nil, false
+	}
+	defer p.mutex.Unlock()
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+
+	e.list = l
+	p.next = e
+	e.prev = p
+	e.next = at
+	at.prev = e
+	atomic.AddInt64(&l.len, 1)
+	return e, true
+}
+
+// remove removes e from its list, decrements l.len. Returns e and whether this call removed it.
+func (l *IntList) remove(e *IntElement) (*IntElement, bool) {
+	p := l.predecessor(e)
+	if p == nil {
+		// Someone else already deleted e for us, we're done
+		return e, false
+	}
+	defer p.mutex.Unlock()
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	n := e.next
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	atomic.AddInt64(&l.len, -1)
+	p.next = n
+	n.prev = p
+	e.next = nil // avoid memory leaks
+	e.prev = nil // avoid memory leaks
+	e.list = nil
+	return e, true
+}
+
+// Remove removes e from l if e is an element of list l.
+// It returns the element value e.Value, like List.Remove also if e was not
+// removed.
+// The element must not be nil.
+func (l *IntList) Remove(e *IntElement) int {
+	l.lazyInit(false)
+	l.remove(e)
+	return e.Value
+}
+
+// PushFront inserts a new element e with value v at the front of list l and returns e.
+func (l *IntList) PushFront(v int) *IntElement {
+	return l.InsertAfter(v, &l.head)
+}
+
+// PushBack inserts a new element e with value v at the back of list l and returns e.
+func (l *IntList) PushBack(v int) *IntElement {
+	return l.InsertBefore(v, &l.tail)
+}
+
+// InsertBefore inserts a new element e with value v immediately before mark and returns e.
+// If mark is not an element of l, the list is not modified.
+// The mark must not be nil.
+func (l *IntList) InsertBefore(v int, mark *IntElement) *IntElement {
+	l.lazyInit(false)
+	e, _ := l.insertBefore(&IntElement{Value: v}, mark)
+	return e
+}
+
+// InsertAfter inserts a new element e with value v immediately after mark and returns e.
+// If mark is not an element of l, the list is not modified.
+// The mark must not be nil.
+func (l *IntList) InsertAfter(v int, mark *IntElement) *IntElement {
+	l.lazyInit(false)
+	e, _ := l.insertAfter(&IntElement{Value: v}, mark)
+	return e
+}
+
+// Contains reports whether e is an element of l.
+// The element must not be nil.
+func (l *IntList) Contains(e *IntElement) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.list == l
+}
@@ -0,0 +1,79 @@
+package concurrent
+
+import "testing"
+
+func checkIntList(t *testing.T, l *IntList, es []int) {
+	if n := l.Len(); n != len(es) {
+		t.Errorf("l.Len() = %d, want %d", n, len(es))
+		return
+	}
+
+	i := 0
+	for e := l.Front(); e != nil; e = e.Next() {
+		if e.Value != es[i] {
+			t.Errorf("elt[%d].Value = %d, want %d", i, e.Value, es[i])
+		}
+		i++
+	}
+	i = len(es) - 1
+	for e := l.Back(); e != nil; e = e.Prev() {
+		if e.Value != es[i] {
+			t.Errorf("elt[%d].Value = %d, want %d", i, e.Value, es[i])
+		}
+		i--
+	}
+}
+
+func TestIntList(t *testing.T) {
+	var l IntList // The zero value must be usable
+	checkIntList(t, &l, []int{})
+
+	e2 := l.PushBack(2)
+	e1 := l.PushFront(1)
+	e4 := l.PushBack(4)
+	e3 := l.InsertBefore(3, e4)
+	checkIntList(t, &l, []int{1, 2, 3, 4})
+	if l.Front() != e1 || l.Back() != e4 || e2.Next() != e3 {
+		t.Errorf("elements not linked in insertion order")
+	}
+
+	if v := l.Remove(e3); v != 3 {
+		t.Errorf("Remove(e3) = %d, want 3", v)
+	}
+	checkIntList(t, &l, []int{1, 2, 4})
+	if v := l.Remove(e3); v != 3 {
+		t.Errorf("second Remove(e3) = %d, want 3", v)
+	}
+	if l.Contains(e3) || !l.Contains(e2) {
+		t.Errorf("only e2 should be contained in l")
+	}
+
+	// Inserting relative to an element of another list does nothing
+	other := NewIntList()
+	if e := other.InsertAfter(5, e2); e != nil {
+		t.Errorf("InsertAfter(5, e2) = %p, want nil", e)
+	}
+	checkIntList(t, other, []int{})
+
+	l.Init()
+	checkIntList(t, &l, []int{})
+}
+
+// Values outside of [0, 255] are boxed in a new allocation by PushBack of List
+const benchmarkIntOffset = 1 << 10
+
+func BenchmarkPushBackInt(b *testing.B) {
+	b.ReportAllocs()
+	l := New()
+	for i := 0; i < b.N; i++ {
+		l.PushBack(benchmarkIntOffset + i)
+	}
+}
+
+func BenchmarkIntListPushBack(b *testing.B) {
+	b.ReportAllocs()
+	l := NewIntList()
+	for i := 0; i < b.N; i++ {
+		l.PushBack(benchmarkIntOffset + i)
+	}
+}
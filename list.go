@@ -11,8 +11,14 @@
 package concurrent
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
 	"sync"
 	"sync/atomic"
+	"unsafe"
 )
 
 // Element is an element of a linked list.
@@ -26,10 +32,52 @@ type Element struct {
 	// A mutex protects all accesses to the list
 	mutex sync.RWMutex
 
+	// Serialises updates of Value through the List, which also hold mutex
+	// while writing Value. Never acquired while holding a mutex.
+	valueMutex sync.Mutex
+
+	// Unique identifier, see ID
+	id uint64
+
+	// Number of moves using this element as their mark, which can't be
+	// removed until they complete. Protected by mutex, see List.pin.
+	pins int
+
 	// The value stored with this element.
 	Value interface{}
 }
 
+// Last identifier handed out by nextElementID. Fixed size because of atomic access
+var lastElementID uint64
+
+// nextElementID returns a new unique element identifier, never 0.
+func nextElementID() uint64 {
+	return atomic.AddUint64(&lastElementID, 1)
+}
+
+// ID returns the identifier of e, which is unique among all elements created
+// by this process and stays the same as long as e exists, including when it
+// is moved within or between lists. Elements get their ID when they are
+// created by a List, or for a user-created element, when it is attached.
+// An element that was never inserted into a list has ID 0.
+func (e *Element) ID() uint64 {
+	return e.id
+}
+
+// storeValue sets e.Value. The caller must hold e.valueMutex.
+func (e *Element) storeValue(v interface{}) {
+	e.mutex.Lock()
+	e.Value = v
+	e.mutex.Unlock()
+}
+
+// loadValue returns e.Value, safe against concurrent updates through the List.
+func (e *Element) loadValue() interface{} {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.Value
+}
+
 // Next returns the next list element or nil.
 func (e *Element) Next() *Element {
 	e.mutex.RLock()
@@ -52,15 +100,59 @@ func (e *Element) Prev() *Element {
 	return nil
 }
 
+// NextN returns the element n steps after e, or nil if the walk reaches the
+// end of the list or an element on the way is removed from it. A
+// non-positive n returns e itself.
+func (e *Element) NextN(n int) *Element {
+	for ; n > 0 && e != nil; n-- {
+		e = e.Next()
+	}
+	return e
+}
+
+// PrevN returns the element n steps before e, or nil if the walk reaches the
+// front of the list or an element on the way is removed from it. A
+// non-positive n returns e itself.
+func (e *Element) PrevN(n int) *Element {
+	for ; n > 0 && e != nil; n-- {
+		e = e.Prev()
+	}
+	return e
+}
+
 // List is a doubly linked list
 // Implements the same interface as container.List
 // Code heavily inspired by container.List
+// As for container.List, the zero value is an empty list ready to use:
+// every method that needs the sentinels initialises them first.
 type List struct {
 	// Separate sentinels avoid contention between operations at either end
 	head, tail Element
 
 	// Fixed size because of atomic access
 	len int64
+
+	// Highest length since creation or the last ResetHighWaterMark.
+	// Fixed size because of atomic access
+	highWater int64
+
+	// Callback for length changes, set through OnLenChange
+	onLenChange atomic.Value
+
+	// Preallocated elements for new values, see Grow
+	spareMutex sync.Mutex
+	spare      []Element
+	nSpare     int64 // Fixed size because of atomic access
+
+	// Consumers blocked in WaitNonEmpty, woken up when the length changes
+	waitMutex sync.Mutex
+	waitCond  *sync.Cond // Created on first use, protected by waitMutex
+	nWaiters  int32      // Atomic
+
+	// Diagnostic bound on the attempts of predecessor, which panics when it
+	// runs out so that livelock shows up. 0 retries forever, as by default.
+	// Only set by tests, before l is used.
+	maxPredecessorAttempts int
 }
 
 // init initializes list l.
@@ -75,6 +167,9 @@ func (l *List) lazyInit(clear bool) *List {
 		return l // Nothing to do, so avoid the locking operations
 	}
 
+	newLen := int64(-1)
+	defer l.lenChanged(&newLen) // Runs after all locks are released
+
 	l.head.mutex.Lock()
 	defer l.head.mutex.Unlock()
 	l.tail.mutex.Lock()
@@ -86,7 +181,9 @@ func (l *List) lazyInit(clear bool) *List {
 	}
 
 	if !initialised || clear {
-		atomic.StoreInt64(&l.len, 0)
+		if atomic.SwapInt64(&l.len, 0) != 0 {
+			newLen = 0
+		}
 		l.head.prev = nil
 		l.head.list = l
 		l.head.next = &l.tail
@@ -112,6 +209,102 @@ func New() *List {
 // The complexity is O(1).
 func (l *List) Len() int { return int(atomic.LoadInt64(&l.len)) }
 
+// OnLenChange registers f to be called whenever the length of l changes,
+// replacing any earlier callback. A nil f removes the callback.
+//
+// f is called by the goroutine that changed the length once it has released
+// its locks, so f may access l. Every change results in exactly one call,
+// but a single call may cover several elements, e.g. for PushBackList.
+// Concurrent changes may be reported out of order, so newLen is the length
+// directly after the reported change rather than the current length.
+func (l *List) OnLenChange(f func(newLen int)) {
+	l.onLenChange.Store(f)
+}
+
+// lenChanged reports new length *n to the OnLenChange callback, if any.
+// A negative length means that nothing changed.
+func (l *List) lenChanged(n *int64) {
+	if *n < 0 {
+		return
+	}
+	if *n > 0 && atomic.LoadInt32(&l.nWaiters) > 0 {
+		l.wakeWaiters()
+	}
+	if f, _ := l.onLenChange.Load().(func(int)); f != nil {
+		f(int(*n))
+	}
+}
+
+// HighWaterMark returns the highest length that l has had since it was
+// created or ResetHighWaterMark was last called, for sizing list-backed
+// queues. Clearing l through Init does not reset it.
+func (l *List) HighWaterMark() int {
+	return int(atomic.LoadInt64(&l.highWater))
+}
+
+// ResetHighWaterMark lowers the high-water mark of l to its current length,
+// so that HighWaterMark reports the peak length from now on.
+func (l *List) ResetHighWaterMark() {
+	atomic.StoreInt64(&l.highWater, atomic.LoadInt64(&l.len))
+}
+
+// raiseHighWaterMark raises the high-water mark of l to newLen, if higher.
+func (l *List) raiseHighWaterMark(newLen int64) {
+	for {
+		mark := atomic.LoadInt64(&l.highWater)
+		if newLen <= mark || atomic.CompareAndSwapInt64(&l.highWater, mark, newLen) {
+			return
+		}
+	}
+}
+
+// WaitNonEmpty blocks until l holds at least one element or ctx is
+// cancelled, and reports whether l was found non-empty. Another goroutine
+// may take the element before the caller gets to it, so consumers should be
+// prepared to find l empty again and wait anew.
+func (l *List) WaitNonEmpty(ctx context.Context) bool {
+	if l.Len() > 0 {
+		return true
+	}
+
+	l.waitMutex.Lock()
+	defer l.waitMutex.Unlock()
+	if l.waitCond == nil {
+		l.waitCond = sync.NewCond(&l.waitMutex)
+	}
+	// Register before checking the length, so an insertion either sees us
+	// waiting or is seen by the check, see lenChanged
+	atomic.AddInt32(&l.nWaiters, 1)
+	defer atomic.AddInt32(&l.nWaiters, -1)
+
+	if done := ctx.Done(); done != nil {
+		waiting := make(chan struct{})
+		defer close(waiting)
+		go func() {
+			select {
+			case <-done:
+				l.wakeWaiters()
+			case <-waiting:
+			}
+		}()
+	}
+
+	for l.Len() == 0 {
+		if ctx.Err() != nil {
+			return false
+		}
+		l.waitCond.Wait()
+	}
+	return true
+}
+
+// wakeWaiters wakes up all goroutines blocked in WaitNonEmpty.
+func (l *List) wakeWaiters() {
+	l.waitMutex.Lock()
+	l.waitCond.Broadcast()
+	l.waitMutex.Unlock()
+}
+
 // Front returns the first element of list l or nil if the list is empty.
 func (l *List) Front() *Element {
 	if l.Len() == 0 {
@@ -129,7 +322,7 @@ func (l *List) Front() *Element {
 
 // Back returns the last element of list l or nil if the list is empty.
 func (l *List) Back() *Element {
-	if l.len == 0 {
+	if l.Len() == 0 {
 		return nil
 	}
 
@@ -144,15 +337,68 @@ func (l *List) Back() *Element {
 	return l.tail.prev
 }
 
+// PeekFront returns the value of the first element of l, and false if l is
+// empty. Unlike l.Front().Value, it reads the value while the element is
+// still first, and it can't dereference nil if l is emptied concurrently.
+func (l *List) PeekFront() (interface{}, bool) {
+	if l.Len() == 0 {
+		return nil, false
+	}
+
+	l.head.mutex.RLock()
+	defer l.head.mutex.RUnlock()
+	e := l.head.next
+	if e == nil || e == &l.tail {
+		return nil, false
+	}
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.Value, true
+}
+
+// PeekBack returns the value of the last element of l, and false if l is
+// empty, like PeekFront does for the first element.
+func (l *List) PeekBack() (interface{}, bool) {
+	if l.Len() == 0 {
+		return nil, false
+	}
+
+	e := l.predecessor(&l.tail)
+	if e == nil {
+		return nil, false // l is not initialised
+	}
+	defer e.mutex.Unlock()
+	if e == &l.head {
+		return nil, false
+	}
+	return e.Value, true
+}
+
+// claim marks the range [first, last] as elements of l, and gives those
+// that don't have one yet an ID.
+// Only once insertion succeeds, so failed insertions don't leave them claimed.
+func (l *List) claim(first, last *Element) {
+	for e := first; ; e = e.next {
+		e.list = l
+		if e.id == 0 {
+			e.id = nextElementID()
+		}
+		if e == last {
+			return
+		}
+	}
+}
+
 // insertAfter inserts range [first, last] after at, increments l.len, and returns first.
 // Elements in inserted range must not be accessed simultaneously.
 func (l *List) insertAfter(first, last, at *Element) (*Element, bool) {
+	newLen := int64(-1)
+	defer l.lenChanged(&newLen) // Runs after all locks are released
+
 	nAdded := 1
 	for e := first; e != last; e = e.next {
-		e.list = l
 		nAdded++
 	}
-	last.list = l
 
 	at.mutex.Lock()
 	defer at.mutex.Unlock()
@@ -170,51 +416,115 @@ func (l *List) insertAfter(first, last, at *Element) (*Element, bool) {
 	n.mutex.Lock()
 	defer n.mutex.Unlock()
 
+	l.claim(first, last)
 	at.next = first
 	first.prev = at
 	last.next = n
 	n.prev = last
-	atomic.AddInt64(&l.len, int64(nAdded))
+	newLen = atomic.AddInt64(&l.len, int64(nAdded))
+	l.raiseHighWaterMark(newLen)
 	return first, true
 }
 
+// newElement returns a new element with value v, preallocated by Grow if possible.
+func (l *List) newElement(v interface{}) *Element {
+	if atomic.LoadInt64(&l.nSpare) > 0 {
+		l.spareMutex.Lock()
+		if len(l.spare) > 0 {
+			e := &l.spare[0]
+			l.spare = l.spare[1:]
+			atomic.StoreInt64(&l.nSpare, int64(len(l.spare)))
+			l.spareMutex.Unlock()
+			e.id = nextElementID()
+			e.Value = v
+			return e
+		}
+		l.spareMutex.Unlock()
+	}
+	return &Element{id: nextElementID(), Value: v}
+}
+
+// Grow preallocates elements, if needed, so that the next n values inserted
+// into l don't need to allocate an element each.
+// It is a hint: concurrent insertions compete for the preallocated elements,
+// and a Grow that allocates discards the elements left by an earlier one.
+// The preallocated elements are allocated in one block, which stays in memory
+// as long as any element of it does.
+func (l *List) Grow(n int) {
+	if n <= int(atomic.LoadInt64(&l.nSpare)) {
+		return
+	}
+	spare := make([]Element, n)
+
+	l.spareMutex.Lock()
+	defer l.spareMutex.Unlock()
+	if n > len(l.spare) {
+		l.spare = spare
+		atomic.StoreInt64(&l.nSpare, int64(n))
+	}
+}
+
 // insertValue is a convenience wrapper for insert(&Element{Value: v}, at).
 func (l *List) insertValueAfter(v interface{}, at *Element) (*Element, bool) {
-	e := &Element{Value: v}
+	e := l.newElement(v)
 	return l.insertAfter(e, e, at)
 }
 
 // Returns the predecessor of e in l in a thread safe way.
 // The returned element, if not nil, is locked for writing.
 func (l *List) predecessor(e *Element) *Element {
+	p, err := l.predecessorBounded(e, l.maxPredecessorAttempts)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// livelockError reports that predecessorBounded ran out of attempts.
+type livelockError struct {
+	attempts int
+}
+
+func (err *livelockError) Error() string {
+	return fmt.Sprintf("concurrent: predecessor kept changing for %d attempts", err.attempts)
+}
+
+// predecessorBounded is predecessor, but gives up with a *livelockError after
+// maxAttempts attempts to lock the predecessor of e. A non-positive
+// maxAttempts retries forever, like predecessor. Nothing is locked on error.
+func (l *List) predecessorBounded(e *Element, maxAttempts int) (*Element, error) {
 	e.mutex.RLock()
 	p := e.prev
-	for ; e.list == l && p != nil; p = e.prev {
+	for attempts := 1; e.list == l && p != nil; attempts, p = attempts+1, e.prev {
 		// We must unlock here to avoid deadlock: Always lock head-to-tail
 		e.mutex.RUnlock()
 		p.mutex.Lock()
 		if p.next == e {
-			return p
+			return p, nil
 		}
 		// We got a new predecessor before we got the lock, try again
 		p.mutex.Unlock()
+		if attempts == maxAttempts {
+			return nil, &livelockError{attempts}
+		}
 		e.mutex.RLock()
 	}
 	// If the loop terminates without returning, e was removed from l
 	e.mutex.RUnlock()
-	return nil
+	return nil, nil
 }
 
 // insertBefore inserts range [first, last] before at, increments l.len.
 // Returns the last inserted element, if any, and whether insertion was successful.
 // Elements in inserted range must not be accessed simultaneously.
 func (l *List) insertBefore(first, last, at *Element) (*Element, bool) {
+	newLen := int64(-1)
+	defer l.lenChanged(&newLen) // Runs after all locks are released
+
 	nAdded := 1
 	for e := first; e != last; e = e.next {
-		e.list = l
 		nAdded++
 	}
-	last.list = l
 
 	p := l.predecessor(at)
 	if p == nil {
@@ -231,41 +541,143 @@ func (l *List) insertBefore(first, last, at *Element) (*Element, bool) {
 	at.mutex.Lock()
 	defer at.mutex.Unlock()
 
+	l.claim(first, last)
 	p.next = first
 	first.prev = p
 	last.next = at
 	at.prev = last
-	atomic.AddInt64(&l.len, int64(nAdded))
+	newLen = atomic.AddInt64(&l.len, int64(nAdded))
+	l.raiseHighWaterMark(newLen)
 	return last, true
 }
 
 // insertValue is a convenience wrapper for insert(&Element{Value: v}, at).
 func (l *List) insertValueBefore(v interface{}, at *Element) (*Element, bool) {
-	e := &Element{Value: v}
+	e := l.newElement(v)
 	return l.insertBefore(e, e, at)
 }
 
 // remove removes e from its list, decrements l.len. Returns e and whether this call removed it.
+// If e is the mark of a move in progress, it waits for the move to complete.
 func (l *List) remove(e *Element) (*Element, bool) {
+	for {
+		if removed, pinned := l.tryRemove(e); !pinned {
+			return e, removed
+		}
+		runtime.Gosched()
+	}
+}
+
+// tryRemove makes one attempt to remove e from its list, see remove.
+// Returns whether this call removed e, or whether it couldn't because e is
+// the mark of a move in progress.
+func (l *List) tryRemove(e *Element) (removed, pinned bool) {
+	newLen := int64(-1)
+	defer l.lenChanged(&newLen) // Runs after all locks are released
+
 	p := l.predecessor(e)
 	if p == nil {
 		// Someone else already deleted e for us, we're done
-		return e, false
+		return false, false
 	}
 	defer p.mutex.Unlock()
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
+	if e.pins > 0 {
+		return false, true
+	}
 	n := e.next
 	n.mutex.Lock()
 	defer n.mutex.Unlock()
 
-	atomic.AddInt64(&l.len, -1)
+	newLen = atomic.AddInt64(&l.len, -1)
 	p.next = n
 	n.prev = p
 	e.next = nil // avoid memory leaks
 	e.prev = nil // avoid memory leaks
 	e.list = nil
-	return e, true
+	return true, false
+}
+
+// pin marks at as the mark of a move, so that it stays in l until unpin.
+// Returns false if at is not an element of l.
+func (l *List) pin(at *Element) bool {
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+	if at.list != l {
+		return false
+	}
+	at.pins++
+	return true
+}
+
+// unpin releases a pin on at taken by pin.
+func unpin(at *Element) {
+	at.mutex.Lock()
+	at.pins--
+	at.mutex.Unlock()
+}
+
+// lockRun write-locks the elements following p, which must be write-locked,
+// up to and including last and its successor. It returns that successor and
+// the number of elements from p.next to last, or nil if last does not follow p
+// in l, in which case only p remains locked.
+func (l *List) lockRun(p, last *Element) (*Element, int) {
+	if last == p {
+		return nil, 0 // last is in front of the run
+	}
+	count := 0
+	for e := p; e != last; count++ {
+		n := e.next
+		if n == &l.tail || n == nil {
+			// Reached the end of l without finding last
+			if e != p {
+				unlockRun(p.next, e)
+			}
+			return nil, 0
+		}
+		n.mutex.Lock()
+		e = n
+	}
+	n := last.next
+	n.mutex.Lock()
+	return n, count
+}
+
+// unlockRun unlocks the write-locked elements from first to last.
+func unlockRun(first, last *Element) {
+	for e := first; ; {
+		next := e.next // Read before unlocking, next is still locked by us
+		e.mutex.Unlock()
+		if e == last {
+			return
+		}
+		e = next
+	}
+}
+
+// removeRun unlinks the count elements from first to last from l, where p and
+// n are the neighbours of the run. All of them must be write-locked; the
+// removed elements are unlocked, and visit is called for each if not nil.
+// Returns the new length of l.
+func (l *List) removeRun(p, first, last, n *Element, count int, visit func(e *Element)) int64 {
+	p.next = n
+	n.prev = p
+	newLen := atomic.AddInt64(&l.len, -int64(count))
+	for e := first; ; {
+		next := e.next
+		e.next = nil // avoid memory leaks
+		e.prev = nil // avoid memory leaks
+		e.list = nil
+		if visit != nil {
+			visit(e)
+		}
+		e.mutex.Unlock()
+		if e == last {
+			return newLen
+		}
+		e = next
+	}
 }
 
 // move moves e to next to at and returns e and whether move succeeded.
@@ -284,13 +696,8 @@ func (l *List) moveAfter(e, at *Element) (*Element, bool) {
 		return e, false
 	}
 	at.mutex.RUnlock()
-	// TODO: race condition if at is removed from l between here and inserting e
-	// e will be removed from l and not inserted again
 
-	_, ok := l.remove(e)
-	if ok {
-		_, ok = l.insertAfter(e, e, at)
-	}
+	_, ok := l.moveWithPin(e, at, l.insertAfter)
 	return e, ok
 }
 
@@ -310,26 +717,374 @@ func (l *List) moveBefore(e, at *Element) (*Element, bool) {
 		return e, false
 	}
 	at.mutex.RUnlock()
-	// TODO: race condition if at is removed from l between here and inserting e
-	// e will be removed from l and not inserted again
 
-	_, ok := l.remove(e)
-	if ok {
-		_, ok = l.insertBefore(e, e, at)
-	}
+	_, ok := l.moveWithPin(e, at, l.insertBefore)
 	return e, ok
 }
 
+// moveWithPin removes e and inserts it next to at through insert, while at is
+// pinned so that it can't be removed in between, which would lose e.
+// Returns e and whether the move succeeded.
+func (l *List) moveWithPin(e, at *Element, insert func(first, last, at *Element) (*Element, bool)) (*Element, bool) {
+	for {
+		if !l.pin(at) {
+			return e, false
+		}
+		removed, pinned := l.tryRemove(e)
+		if pinned {
+			// e is the mark of another move, which may wait for at. Back off
+			unpin(at)
+			runtime.Gosched()
+			continue
+		}
+		ok := removed
+		if ok {
+			_, ok = insert(e, e, at)
+		}
+		unpin(at)
+		return e, ok
+	}
+}
+
 // Remove removes e from l if e is an element of list l.
-// It returns the element value e.Value.
+// It returns the element value e.Value, like container/list also if e was
+// not removed; Detach reports whether it was.
 // The element must not be nil.
 func (l *List) Remove(e *Element) interface{} {
 	l.lazyInit(false)
-	e, ok := l.remove(e)
-	if ok {
-		return e.Value
+	l.remove(e)
+	return e.loadValue()
+}
+
+// Detach removes e from l, like Remove, but returns e itself so that it can
+// be reused, e.g. to move it into another list through AttachAfter without
+// allocating a new element. The detached element keeps its Value.
+// It returns e and whether e was an element of l.
+// The element must not be nil.
+func (l *List) Detach(e *Element) (*Element, bool) {
+	l.lazyInit(false)
+	return l.remove(e)
+}
+
+// AttachAfter inserts the detached element e immediately after mark, and
+// reports whether it did. If mark is not an element of l, or e is still an
+// element of a list, the list is not modified.
+// e must not be accessed concurrently. The element and mark must not be nil.
+func (l *List) AttachAfter(e, mark *Element) bool {
+	l.lazyInit(false)
+	if !isDetached(e) {
+		return false
 	}
-	return nil
+	_, ok := l.insertAfter(e, e, mark)
+	return ok
+}
+
+// AttachBefore inserts the detached element e immediately before mark, and
+// reports whether it did. If mark is not an element of l, or e is still an
+// element of a list, the list is not modified.
+// e must not be accessed concurrently. The element and mark must not be nil.
+func (l *List) AttachBefore(e, mark *Element) bool {
+	l.lazyInit(false)
+	if !isDetached(e) {
+		return false
+	}
+	_, ok := l.insertBefore(e, e, mark)
+	return ok
+}
+
+// Balance moves elements between l and other until their lengths differ by
+// at most one. It takes them from the back of the longer list, one at a time,
+// and appends them to the back of the shorter one, keeping their order.
+// Each element moves atomically, but the lists may be changed concurrently,
+// so the balance is best effort: it holds when Balance returns only if there
+// were no concurrent changes.
+func (l *List) Balance(other *List) {
+	if l == other {
+		return
+	}
+	l.lazyInit(false)
+	other.lazyInit(false)
+
+	var to *List
+	var mark *Element // The element moved last, to insert the next one before
+	for {
+		from, shorter := l, other
+		if shorter.Len() > from.Len() {
+			from, shorter = shorter, from
+		}
+		if from.Len()-shorter.Len() <= 1 {
+			return
+		}
+		if to != shorter {
+			to, mark = shorter, &shorter.tail
+		}
+
+		e := from.Back()
+		if e == nil {
+			continue // Emptied concurrently
+		}
+		if _, ok := from.remove(e); !ok {
+			continue // Removed concurrently, try the next one
+		}
+		if _, ok := to.insertBefore(e, e, mark); !ok {
+			// The element moved last is gone, append instead
+			to.insertBefore(e, e, &to.tail)
+		}
+		mark = e
+	}
+}
+
+// isDetached reports whether e is not an element of any list.
+func isDetached(e *Element) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.list == nil
+}
+
+// DeleteRange removes the contiguous run of elements from first to last,
+// inclusive, from l as a single atomic operation. It returns the number of
+// elements removed, or 0 and false if first is not an element of l or last
+// does not follow it in l, in which case l is not modified.
+// The elements must not be nil.
+func (l *List) DeleteRange(first, last *Element) (int, bool) {
+	l.lazyInit(false)
+	newLen := int64(-1)
+	defer l.lenChanged(&newLen) // Runs after all locks are released
+
+	for {
+		p := l.predecessor(first)
+		if p == nil {
+			return 0, false // first is not in l
+		}
+		n, count := l.lockRun(p, last)
+		if n == nil {
+			p.mutex.Unlock()
+			return 0, false
+		}
+		if runPinned(first, last) {
+			// Wait for the moves using elements of the run as mark
+			unlockRun(p, n)
+			runtime.Gosched()
+			continue
+		}
+
+		newLen = l.removeRun(p, first, last, n, count, nil)
+		p.mutex.Unlock()
+		n.mutex.Unlock()
+		return count, true
+	}
+}
+
+// TakeFront removes up to n elements from the front of l as a single atomic
+// operation and returns their values from front to back. It returns fewer
+// than n values only if l holds fewer elements, and nil if l is empty or n
+// is not positive.
+func (l *List) TakeFront(n int) []interface{} {
+	if n <= 0 {
+		return nil
+	}
+	l.lazyInit(false)
+	newLen := int64(-1)
+	defer l.lenChanged(&newLen) // Runs after all locks are released
+
+	for {
+		p := &l.head
+		p.mutex.Lock()
+		last, count := l.lockUpTo(p, n)
+		if values, ok := l.takeRun(p, last, count, &newLen); ok {
+			return values
+		}
+		runtime.Gosched()
+	}
+}
+
+// TakeBack removes up to n elements from the back of l as a single atomic
+// operation and returns their values from back to front, like repeated calls
+// of Remove(l.Back()). It returns fewer than n values only if l holds fewer
+// elements, and nil if l is empty or n is not positive.
+func (l *List) TakeBack(n int) []interface{} {
+	if n <= 0 {
+		return nil
+	}
+	l.lazyInit(false)
+	newLen := int64(-1)
+	defer l.lenChanged(&newLen) // Runs after all locks are released
+
+	for {
+		// Find the predecessor of the run by stepping back from the tail
+		p := &l.tail
+		for i := 0; i <= n && p != &l.head && p != nil; i++ {
+			if p = l.predecessor(p); p != nil {
+				p.mutex.Unlock()
+			}
+		}
+		if p == nil {
+			continue // Stepped onto an element that was removed, start over
+		}
+
+		// Lock the run head-to-tail, and check it still ends at the tail
+		p.mutex.Lock()
+		if p.list != l {
+			p.mutex.Unlock()
+			continue
+		}
+		last, count := l.lockUpTo(p, n)
+		if last.next != &l.tail || (count < n && p != &l.head) {
+			unlockRun(p, last)
+			continue
+		}
+		if values, ok := l.takeRun(p, last, count, &newLen); ok {
+			for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+				values[i], values[j] = values[j], values[i]
+			}
+			return values
+		}
+		runtime.Gosched()
+	}
+}
+
+// RemoveAll removes all elements from l as a single atomic operation and
+// returns their values from front to back, or nil if l is empty. Unlike Init,
+// it detaches the removed elements, so it is safe while l is accessed
+// concurrently. Elements inserted concurrently either are removed, or remain
+// in l afterwards.
+func (l *List) RemoveAll() []interface{} {
+	return l.TakeFront(maxInt)
+}
+
+// Largest value of int
+const maxInt = int(^uint(0) >> 1)
+
+// lockUpTo write-locks up to n elements following p, which must be
+// write-locked, stopping before the tail. It returns the last locked element,
+// which is p if none were locked, and the number of elements locked after p.
+func (l *List) lockUpTo(p *Element, n int) (*Element, int) {
+	last, count := p, 0
+	for ; count < n && last.next != &l.tail; count++ {
+		last.next.mutex.Lock()
+		last = last.next
+	}
+	return last, count
+}
+
+// takeRun removes the count elements following p up to last, which must all
+// be write-locked along with p, and returns their values from front to back.
+// It stores the new length of l in newLen. If any of the elements is pinned,
+// it unlocks them all and returns false, so the caller can retry.
+func (l *List) takeRun(p, last *Element, count int, newLen *int64) ([]interface{}, bool) {
+	if count == 0 {
+		p.mutex.Unlock()
+		return nil, true
+	}
+	first := p.next
+	if runPinned(first, last) {
+		// Wait for the moves using elements of the run as mark
+		unlockRun(p, last)
+		return nil, false
+	}
+	n := last.next
+	n.mutex.Lock()
+	values := make([]interface{}, 0, count)
+	*newLen = l.removeRun(p, first, last, n, count, func(e *Element) {
+		values = append(values, e.Value)
+	})
+	p.mutex.Unlock()
+	n.mutex.Unlock()
+	return values, true
+}
+
+// runPinned reports whether any element from first to last is pinned.
+// The elements must be locked.
+func runPinned(first, last *Element) bool {
+	for e := first; ; e = e.next {
+		if e.pins > 0 {
+			return true
+		}
+		if e == last {
+			return false
+		}
+	}
+}
+
+// Uniq removes the elements of l whose value is equal, according to eq, to
+// the value of the element before them, keeping the first of each run of
+// equal values. Each run is removed atomically, but concurrent changes of l
+// may leave duplicates, and the walk stops early if the current element is
+// removed concurrently. It returns the number of elements removed.
+func (l *List) Uniq(eq func(a, b interface{}) bool) int {
+	removed := 0
+	for e := l.Front(); e != nil; {
+		v := e.loadValue()
+		var first, last *Element
+		n := e.Next()
+		for ; n != nil && eq(v, n.loadValue()); n = n.Next() {
+			if first == nil {
+				first = n
+			}
+			last = n
+		}
+		if first != nil {
+			count, _ := l.DeleteRange(first, last)
+			removed += count
+		}
+		e = n
+	}
+	return removed
+}
+
+// DedupAll removes the elements of l whose key, as returned by key for their
+// value, equals that of an element before them, keeping the first element
+// with each key in place. The keys must be comparable, like map keys.
+// Concurrent changes of l may leave duplicates, and the walk stops early if
+// the next element is removed concurrently. It returns the number of elements
+// removed. The complexity is O(l.Len()).
+func (l *List) DedupAll(key func(v interface{}) interface{}) int {
+	l.lazyInit(false)
+	seen := make(map[interface{}]struct{})
+	removed := 0
+	for e := l.Front(); e != nil; {
+		n := e.Next()
+		k := key(e.loadValue())
+		if _, ok := seen[k]; !ok {
+			seen[k] = struct{}{}
+		} else if _, ok := l.remove(e); ok {
+			removed++
+		}
+		e = n
+	}
+	return removed
+}
+
+// RetainIf removes the elements of l whose value doesn't satisfy pred, and
+// returns the number of elements removed. Each element is checked and removed
+// atomically, so a concurrent update of its value can't slip in between, but
+// elements inserted concurrently may be missed, and the walk stops early if
+// the next element is removed concurrently. The complexity is O(l.Len()).
+// pred must not access l.
+func (l *List) RetainIf(pred func(v interface{}) bool) int {
+	l.lazyInit(false)
+	removed := 0
+	for e := l.Front(); e != nil; {
+		n := e.Next()
+		if l.removeUnless(e, pred) {
+			removed++
+		}
+		e = n
+	}
+	return removed
+}
+
+// removeUnless removes element e from l unless pred returns true for its
+// value, and reports whether it did.
+func (l *List) removeUnless(e *Element, pred func(v interface{}) bool) bool {
+	e.valueMutex.Lock()
+	defer e.valueMutex.Unlock()
+
+	if !l.Contains(e) || pred(e.Value) {
+		return false
+	}
+	_, ok := l.remove(e)
+	return ok
 }
 
 // PushFront inserts a new element e with value v at the front of list l and returns e.
@@ -346,7 +1101,6 @@ func (l *List) PushBack(v interface{}) *Element {
 // If mark is not an element of l, the list is not modified.
 // The mark must not be nil.
 func (l *List) InsertBefore(v interface{}, mark *Element) *Element {
-	// see comment in List.Remove about initialization of l
 	l.lazyInit(false)
 	e, _ := l.insertValueBefore(v, mark)
 	return e
@@ -361,25 +1115,191 @@ func (l *List) InsertAfter(v interface{}, mark *Element) *Element {
 	return e
 }
 
+// InsertBeforeOK is like InsertBefore, but also reports whether mark was an
+// element of l, i.e. whether e was inserted.
+// The mark must not be nil.
+func (l *List) InsertBeforeOK(v interface{}, mark *Element) (*Element, bool) {
+	l.lazyInit(false)
+	return l.insertValueBefore(v, mark)
+}
+
+// InsertAfterOK is like InsertAfter, but also reports whether mark was an
+// element of l, i.e. whether e was inserted.
+// The mark must not be nil.
+func (l *List) InsertAfterOK(v interface{}, mark *Element) (*Element, bool) {
+	l.lazyInit(false)
+	return l.insertValueAfter(v, mark)
+}
+
+// Contains reports whether e is an element of l.
+// During a move of e within l, it may briefly report false.
+// The element must not be nil.
+func (l *List) Contains(e *Element) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.list == l
+}
+
 // MoveToFront moves element e to the front of list l.
 // If e is not an element of l, the list is not modified.
 // The element must not be nil.
 func (l *List) MoveToFront(e *Element) {
-	if e.list != l {
+	l.lazyInit(false)
+	if !l.Contains(e) {
 		return
 	}
-	// see comment in List.Remove about initialization of l
 	l.moveAfter(e, &l.head)
 }
 
+// MoveToFrontBatch moves the elements es to the front of list l, in the
+// order of es, so es[0] ends up first, as a single atomic splice: concurrent
+// operations see either none or all of the moves, and the elements stay in l
+// throughout. Elements not in l, and repeated elements after their first
+// occurrence, are skipped. Like RotateToFront, it locks all elements of l, so
+// it takes O(l.Len()) time and holds off all other operations on l meanwhile.
+// The elements must not be nil.
+func (l *List) MoveToFrontBatch(es []*Element) {
+	if len(es) == 0 {
+		return
+	}
+	l.lockAll()
+	defer unlockRun(&l.head, &l.tail) // Follows the new links, which still reach all
+
+	// Only the elements of l are locked, so find those among es by walking l
+	// rather than by reading the list of each element
+	inList := make(map[*Element]bool, len(es))
+	for _, e := range es {
+		inList[e] = false
+	}
+	for x := l.head.next; x != &l.tail; x = x.next {
+		if _, ok := inList[x]; ok {
+			inList[x] = true
+		}
+	}
+
+	at := &l.head
+	for _, e := range es {
+		if !inList[e] {
+			continue
+		}
+		inList[e] = false // Skip repetitions
+		e.prev.next = e.next
+		e.next.prev = e.prev
+		e.prev = at
+		e.next = at.next
+		at.next.prev = e
+		at.next = e
+		at = e
+	}
+}
+
+// RotateToFront rotates l so that e becomes its front element, moving the
+// elements before e to the back in their order, as a single atomic splice.
+// It locks all elements of l to do so, so it takes O(l.Len()) time and holds
+// off all other operations on l meanwhile.
+// It reports whether e is an element of l. The element must not be nil.
+func (l *List) RotateToFront(e *Element) bool {
+	last, _ := l.lockAll()
+	defer unlockRun(&l.head, &l.tail) // Follows the new links, which still reach all
+
+	for x := l.head.next; x != &l.tail; x = x.next {
+		if x == e {
+			l.spliceToFront(e, last)
+			return true
+		}
+	}
+	return false
+}
+
+// RotateLeft rotates l by moving its first n elements to the back, in their
+// order, as a single atomic splice. n is clamped to l.Len(). It returns the
+// moved elements from front to back. Like RotateToFront, it locks all
+// elements of l, so it takes O(l.Len()) time.
+func (l *List) RotateLeft(n int) []*Element {
+	return l.rotate(n, true)
+}
+
+// RotateRight rotates l by moving its last n elements to the front, in their
+// order, as a single atomic splice. n is clamped to l.Len(). It returns the
+// moved elements from front to back. Like RotateToFront, it locks all
+// elements of l, so it takes O(l.Len()) time.
+func (l *List) RotateRight(n int) []*Element {
+	return l.rotate(n, false)
+}
+
+// rotate moves the first n elements of l to the back if left is set, or the
+// last n elements to the front otherwise, and returns them, see RotateLeft.
+func (l *List) rotate(n int, left bool) []*Element {
+	last, count := l.lockAll()
+	defer unlockRun(&l.head, &l.tail) // Follows the new links, which still reach all
+
+	if n > count {
+		n = count
+	}
+	if n <= 0 {
+		return nil
+	}
+	start := 0 // Index of the first moved element
+	if !left {
+		start = count - n
+	}
+	e := l.head.next
+	for i := 0; i < start; i++ {
+		e = e.next
+	}
+	moved := make([]*Element, n)
+	for i := range moved {
+		moved[i] = e
+		e = e.next
+	}
+
+	// Rotating left, e now follows the moved elements and becomes the front
+	front := e
+	if !left {
+		front = moved[0]
+	}
+	if front != &l.tail {
+		l.spliceToFront(front, last)
+	}
+	return moved
+}
+
+// lockAll write-locks all elements of l including the sentinels, which the
+// caller unlocks through unlockRun(&l.head, &l.tail). It returns the back
+// element, which is the head if l is empty, and the number of elements.
+func (l *List) lockAll() (*Element, int) {
+	l.lazyInit(false)
+	l.head.mutex.Lock()
+	last, count := l.lockUpTo(&l.head, maxInt)
+	l.tail.mutex.Lock()
+	return last, count
+}
+
+// spliceToFront moves e and the elements after it in front of the elements
+// before e, keeping their orders. The caller must hold all locks, see
+// lockAll, which also returns last, the back element.
+func (l *List) spliceToFront(e, last *Element) {
+	h, t := &l.head, &l.tail
+	if e == h.next {
+		return
+	}
+	first, before := h.next, e.prev
+	h.next = e
+	e.prev = h
+	last.next = first
+	first.prev = last
+	before.next = t
+	t.prev = before
+}
+
 // MoveToBack moves element e to the back of list l.
 // If e is not an element of l, the list is not modified.
 // The element must not be nil.
 func (l *List) MoveToBack(e *Element) {
-	if e.list != l {
+	l.lazyInit(false)
+	if !l.Contains(e) {
 		return
 	}
-	// see comment in List.Remove about initialization of l
 	l.moveBefore(e, &l.tail)
 }
 
@@ -387,6 +1307,7 @@ func (l *List) MoveToBack(e *Element) {
 // If e or mark is not an element of l, or e == mark, the list is not modified.
 // The element and mark must not be nil.
 func (l *List) MoveBefore(e, mark *Element) {
+	l.lazyInit(false)
 	l.moveBefore(e, mark)
 }
 
@@ -394,9 +1315,703 @@ func (l *List) MoveBefore(e, mark *Element) {
 // If e or mark is not an element of l, or e == mark, the list is not modified.
 // The element and mark must not be nil.
 func (l *List) MoveAfter(e, mark *Element) {
+	l.lazyInit(false)
 	l.moveAfter(e, mark)
 }
 
+// MoveBeforeOK is like MoveBefore, but reports whether e and mark were
+// elements of l, i.e. whether e is now positioned before mark.
+// The element and mark must not be nil.
+func (l *List) MoveBeforeOK(e, mark *Element) bool {
+	l.lazyInit(false)
+	if !l.Contains(e) {
+		return false
+	}
+	_, ok := l.moveBefore(e, mark)
+	return ok
+}
+
+// MoveAfterOK is like MoveAfter, but reports whether e and mark were
+// elements of l, i.e. whether e is now positioned after mark.
+// The element and mark must not be nil.
+func (l *List) MoveAfterOK(e, mark *Element) bool {
+	l.lazyInit(false)
+	if !l.Contains(e) {
+		return false
+	}
+	_, ok := l.moveAfter(e, mark)
+	return ok
+}
+
+// MoveToFrontN moves element e to position n of list l, counting from 0 at
+// the front. An n beyond the end of l moves e to the back, a negative n to the
+// front. It locks the first n+1 elements of l besides e, and e itself, while
+// it finds the position and moves e, so concurrent changes can't shift the
+// position in the meantime.
+// It reports whether e was an element of l and has been moved.
+// The element must not be nil.
+func (l *List) MoveToFrontN(e *Element, n int) bool {
+	l.lazyInit(false)
+	if n < 0 {
+		n = 0
+	}
+
+	// e will be inserted before mark, the n-th element of the others. Lock
+	// all elements up to it, so none can be inserted or removed in front.
+	l.head.mutex.Lock()
+	mark, found := &l.head, false
+	for i := 0; i <= n && mark != &l.tail; {
+		mark = mark.next
+		mark.mutex.Lock()
+		if mark == e {
+			found = true
+		} else {
+			i++
+		}
+	}
+	defer unlockRun(&l.head, mark) // Follows the new links, which still reach all
+
+	if !found {
+		// e is behind mark, if in l at all, so lock it and its neighbours
+		// too. Its predecessor can only be locked already if it is mark.
+		e.mutex.RLock()
+		inList, p := e.list == l, e.prev
+		e.mutex.RUnlock()
+		if !inList {
+			return false
+		}
+		if p != mark {
+			if p = l.predecessor(e); p == nil {
+				return false // e was removed in the meantime
+			}
+			defer p.mutex.Unlock()
+		}
+		e.mutex.Lock()
+		next := e.next
+		next.mutex.Lock()
+		defer next.mutex.Unlock()
+	}
+
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev = mark.prev
+	e.next = mark
+	mark.prev.next = e
+	mark.prev = e
+	return true
+}
+
+// walkLocked calls f for the elements of l from front to back, until f
+// returns false. The elements are read-locked in list order and stay locked
+// until the walk ends, so f sees a consistent snapshot of l.
+// f must not modify l or its elements.
+func (l *List) walkLocked(f func(e *Element) bool) {
+	l.lazyInit(false)
+
+	l.head.mutex.RLock()
+	last := &l.head
+	for e := l.head.next; e != &l.tail; e = e.next {
+		e.mutex.RLock()
+		last = e
+		if !f(e) {
+			break
+		}
+	}
+
+	for e := &l.head; ; {
+		next := e.next // Read before unlocking, next is still locked by us
+		e.mutex.RUnlock()
+		if e == last {
+			return
+		}
+		e = next
+	}
+}
+
+// SliceBetween returns the values of the elements from from to to, inclusive,
+// in list order, taken from a consistent snapshot of l. It returns false if
+// from or to is not an element of l, or to precedes from.
+// The elements must not be nil.
+func (l *List) SliceBetween(from, to *Element) ([]interface{}, bool) {
+	var values []interface{}
+	inRange, ok := false, false
+	l.walkLocked(func(e *Element) bool {
+		if e == from {
+			inRange = true
+		}
+		if !inRange {
+			return e != to // Stop if to precedes from
+		}
+		values = append(values, e.Value)
+		ok = e == to
+		return !ok
+	})
+	if !ok {
+		return nil, false
+	}
+	return values, true
+}
+
+// CountWhere returns the number of elements of l, in a consistent snapshot,
+// whose value satisfies pred. It takes O(l.Len()) time and doesn't allocate.
+// pred must not access l.
+func (l *List) CountWhere(pred func(v interface{}) bool) int {
+	n := 0
+	l.walkLocked(func(e *Element) bool {
+		if pred(e.Value) {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// FirstValueWhere returns the value of the first element of l for which
+// pred returns true, and whether there is such an element.
+// pred must not access l.
+func (l *List) FirstValueWhere(pred func(v interface{}) bool) (interface{}, bool) {
+	var value interface{}
+	found := false
+	l.walkLocked(func(e *Element) bool {
+		if pred(e.Value) {
+			value, found = e.Value, true
+			return false
+		}
+		return true
+	})
+	return value, found
+}
+
+// LastValueWhere returns the value of the last element of l for which pred
+// returns true, and whether there is such an element.
+// pred must not access l.
+func (l *List) LastValueWhere(pred func(v interface{}) bool) (interface{}, bool) {
+	var value interface{}
+	found := false
+	// Walk all elements in the usual order, so that locks are taken head-to-tail
+	l.walkLocked(func(e *Element) bool {
+		if pred(e.Value) {
+			value, found = e.Value, true
+		}
+		return true
+	})
+	return value, found
+}
+
+// Fingerprint returns an order-sensitive hash of the values of l from a
+// consistent snapshot, combining the hashes returned by hashValue for each of
+// them. Lists with equal values in the same order, according to hashValue,
+// have the same fingerprint, so comparing fingerprints cheaply detects
+// whether l changed, with a small chance of missing a change.
+// hashValue must not access l.
+func (l *List) Fingerprint(hashValue func(v interface{}) uint64) uint64 {
+	// FNV-1a over the value hashes rather than over bytes
+	const offset64, prime64 = 14695981039346656037, 1099511628211
+	h := uint64(offset64)
+	l.walkLocked(func(e *Element) bool {
+		h = (h ^ hashValue(e.Value)) * prime64
+		return true
+	})
+	return h
+}
+
+// Min returns the element of l with the smallest value according to less,
+// the first one if several are equally small, or nil if l is empty.
+// It scans a consistent snapshot of l in O(l.Len()) time.
+// less must not access l.
+func (l *List) Min(less func(a, b interface{}) bool) *Element {
+	return l.extreme(less)
+}
+
+// Max returns the element of l with the largest value according to less,
+// the first one if several are equally large, or nil if l is empty.
+// It scans a consistent snapshot of l in O(l.Len()) time.
+// less must not access l.
+func (l *List) Max(less func(a, b interface{}) bool) *Element {
+	return l.extreme(func(a, b interface{}) bool { return less(b, a) })
+}
+
+// extreme returns the first element of l whose value no other value is
+// before according to before, or nil if l is empty.
+func (l *List) extreme(before func(a, b interface{}) bool) *Element {
+	var result *Element
+	l.walkLocked(func(e *Element) bool {
+		if result == nil || before(e.Value, result.Value) {
+			result = e
+		}
+		return true
+	})
+	return result
+}
+
+// CopyTo copies the values of l from front to back into dst, starting at
+// dst[offset], and returns the number of values copied. It copies at most
+// len(dst)-offset values; an offset outside of dst copies nothing.
+func (l *List) CopyTo(dst []interface{}, offset int) int {
+	if offset < 0 || offset >= len(dst) {
+		return 0
+	}
+	n := 0
+	l.walkLocked(func(e *Element) bool {
+		dst[offset+n] = e.Value
+		n++
+		return offset+n < len(dst)
+	})
+	return n
+}
+
+// Slice appends the values of l from front to back, taken from a consistent
+// snapshot, to dst and returns the extended slice. Passing a slice with
+// enough spare capacity, e.g. the result of a previous call resliced to
+// length 0, avoids allocating.
+func (l *List) Slice(dst []interface{}) []interface{} {
+	l.walkLocked(func(e *Element) bool {
+		dst = append(dst, e.Value)
+		return true
+	})
+	return dst
+}
+
+// EncodeJSON writes the values of l from front to back to w as a JSON array,
+// encoding one value at a time rather than the whole list at once. The walk
+// sees concurrent changes of l like stepping with Next, except that the
+// element it is at stays in l until the walk moves on: removing it waits,
+// so the walk never ends early.
+// It returns the first error from encoding or writing.
+func (l *List) EncodeJSON(w io.Writer) error {
+	l.lazyInit(false)
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	e := l.pinNext(&l.head)
+	for sep := ""; e != nil; sep = "," {
+		if err := writeJSONValue(w, sep, e.loadValue()); err != nil {
+			unpin(e)
+			return err
+		}
+		next := l.pinNext(e) // Before unpinning e, which keeps it in l
+		unpin(e)
+		e = next
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// writeJSONValue writes sep followed by the JSON encoding of v to w.
+func writeJSONValue(w io.Writer, sep string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, sep); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// pinNext pins and returns the element after at, or nil if at is the back of
+// l. at must stay in l meanwhile, e.g. because it is pinned or the head.
+func (l *List) pinNext(at *Element) *Element {
+	for {
+		at.mutex.RLock()
+		n := at.next
+		at.mutex.RUnlock()
+		if n == &l.tail {
+			return nil
+		}
+		n.mutex.Lock()
+		if n.list == l && n.prev == at {
+			n.pins++
+			n.mutex.Unlock()
+			return n
+		}
+		// n was removed or moved before we pinned it, at has a new successor
+		n.mutex.Unlock()
+	}
+}
+
+// snapshotValues returns the values of l from front to back, taken from a
+// consistent snapshot.
+func (l *List) snapshotValues() []interface{} {
+	return l.Slice(make([]interface{}, 0, l.Len()))
+}
+
+// DeepCopy returns a new list with copies of the values of l, in the same
+// order, made by copyValue. The values are taken from a consistent snapshot
+// of l, and copied afterwards, so copyValue may access l, e.g. to copy
+// nested lists through DeepCopy again. Cycles of nested lists are not
+// detected; copyValue has to handle them, or it recurses forever.
+func (l *List) DeepCopy(copyValue func(v interface{}) interface{}) *List {
+	values := l.snapshotValues()
+	result := New()
+	result.Grow(len(values))
+	for _, v := range values {
+		result.PushBack(copyValue(v))
+	}
+	return result
+}
+
+// Intersect returns a new list with the values of l that are equal to a value
+// of other according to eq, in the order of l. Each list is read from a
+// consistent snapshot, but not both at the same time.
+// The complexity is O(l.Len()*other.Len()).
+func (l *List) Intersect(other *List, eq func(a, b interface{}) bool) *List {
+	values, others := l.snapshotValues(), other.snapshotValues()
+	result := New()
+	for _, v := range values {
+		if containsValue(others, v, eq) {
+			result.PushBack(v)
+		}
+	}
+	return result
+}
+
+// Union returns a new list with the values of l, followed by the values of
+// other that are not equal to any value of l according to eq. Each list is
+// read from a consistent snapshot, but not both at the same time.
+// The complexity is O(l.Len()*other.Len()).
+func (l *List) Union(other *List, eq func(a, b interface{}) bool) *List {
+	values, others := l.snapshotValues(), other.snapshotValues()
+	result := New()
+	for _, v := range values {
+		result.PushBack(v)
+	}
+	for _, v := range others {
+		if !containsValue(values, v, eq) {
+			result.PushBack(v)
+		}
+	}
+	return result
+}
+
+// MergeSorted returns a new list with the values of l and other, which must
+// both be sorted according to less, merged into sorted order. The merge is
+// stable: of equal values, those of l come first, and each list's keep their
+// order. Each list is read from a consistent snapshot, but not both at the
+// same time. The complexity is O(l.Len()+other.Len()).
+func (l *List) MergeSorted(other *List, less func(a, b interface{}) bool) *List {
+	values, others := l.snapshotValues(), other.snapshotValues()
+	result := New()
+	result.Grow(len(values) + len(others))
+	for len(values) > 0 && len(others) > 0 {
+		if less(others[0], values[0]) {
+			result.PushBack(others[0])
+			others = others[1:]
+		} else {
+			result.PushBack(values[0])
+			values = values[1:]
+		}
+	}
+	for _, v := range values {
+		result.PushBack(v)
+	}
+	for _, v := range others {
+		result.PushBack(v)
+	}
+	return result
+}
+
+// Zip returns a new list with the values of l combined pairwise with those
+// of other by combine, the i-th value of the result combining the i-th
+// values of l and other. It stops at the end of the shorter list. Each list
+// is read from a consistent snapshot, but not both at the same time, and
+// combine is called afterwards, so it may access l and other.
+func (l *List) Zip(other *List, combine func(a, b interface{}) interface{}) *List {
+	values, others := l.snapshotValues(), other.snapshotValues()
+	if len(others) < len(values) {
+		values = values[:len(others)]
+	}
+	result := New()
+	result.Grow(len(values))
+	for i, v := range values {
+		result.PushBack(combine(v, others[i]))
+	}
+	return result
+}
+
+// Partition returns two new lists, matching with the values of l for which
+// pred returns true, and rest with the others, each in the order of l.
+// The values are taken from a consistent snapshot of l, and pred is called
+// afterwards, so it may access l. l itself is not modified.
+func (l *List) Partition(pred func(v interface{}) bool) (matching, rest *List) {
+	values := l.snapshotValues()
+	matching, rest = New(), New()
+	for _, v := range values {
+		if pred(v) {
+			matching.PushBack(v)
+		} else {
+			rest.PushBack(v)
+		}
+	}
+	return matching, rest
+}
+
+// GroupBy returns new lists with the values of l grouped by the key that key
+// returns for them, each in the order of l, in a map from the keys to the
+// lists. The keys must be comparable, like map keys. The values are taken
+// from a consistent snapshot of l, and key is called afterwards, so it may
+// access l. l itself is not modified.
+func (l *List) GroupBy(key func(v interface{}) interface{}) map[interface{}]*List {
+	values := l.snapshotValues()
+	groups := make(map[interface{}]*List)
+	for _, v := range values {
+		k := key(v)
+		group, ok := groups[k]
+		if !ok {
+			group = New()
+			groups[k] = group
+		}
+		group.PushBack(v)
+	}
+	return groups
+}
+
+// containsValue reports whether values holds a value equal to v according to eq.
+func containsValue(values []interface{}, v interface{}, eq func(a, b interface{}) bool) bool {
+	for _, w := range values {
+		if eq(w, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForEachIndexed calls f for the elements of l from front to back, with the
+// index of each among the elements visited, until f returns false. The walk
+// sees concurrent changes of l like stepping with Next, so elements removed
+// before it reaches them are skipped and don't take up an index. f may
+// access l, including removing e. The walk ends early if both the element it
+// is at and the one that followed it are removed.
+func (l *List) ForEachIndexed(f func(index int, e *Element) bool) {
+	for e, i := l.Front(), 0; e != nil; i++ {
+		next := e.Next() // In case f removes e
+		if !f(i, e) {
+			return
+		}
+		if l.Contains(e) {
+			next = e.Next() // Skips the elements removed meanwhile
+		} else if next != nil && !l.Contains(next) {
+			return
+		}
+		e = next
+	}
+}
+
+// EachChunk calls f with the values of l from front to back, in consecutive
+// chunks of size values, except for the last chunk which may be shorter.
+// It stops early if f returns false. The values are taken from a consistent
+// snapshot of l before the first call, so f may access l.
+// Nothing happens if size is not positive.
+func (l *List) EachChunk(size int, f func(values []interface{}) bool) {
+	if size <= 0 {
+		return
+	}
+	values := l.snapshotValues()
+
+	for len(values) > 0 {
+		n := size
+		if n > len(values) {
+			n = len(values)
+		}
+		// Limit the capacity, so f can't append into the next chunk
+		if !f(values[:n:n]) {
+			return
+		}
+		values = values[n:]
+	}
+}
+
+// Stream returns a channel that receives the values of l from front to back,
+// buffering up to bufSize of them, and is closed after the last one. The
+// values are taken from a consistent snapshot of l when Stream is called, so
+// later changes of l don't affect them. If ctx is cancelled first, the
+// remaining values are dropped and the channel is closed early. A negative
+// bufSize is treated as 0.
+func (l *List) Stream(ctx context.Context, bufSize int) <-chan interface{} {
+	if bufSize < 0 {
+		bufSize = 0
+	}
+	return streamValues(ctx, l.snapshotValues(), bufSize)
+}
+
+// Tee is like Stream, but returns two unbuffered channels that each receive
+// all values of the same snapshot of l. Each channel is fed independently,
+// so a slow consumer doesn't hold up the other. A consumer that stops early
+// must cancel ctx to release the goroutine feeding its channel.
+func (l *List) Tee(ctx context.Context) (<-chan interface{}, <-chan interface{}) {
+	values := l.snapshotValues()
+	return streamValues(ctx, values, 0), streamValues(ctx, values, 0)
+}
+
+// streamValues returns a channel with bufSize buffer slots that receives
+// values, see Stream. values must not be modified afterwards.
+func streamValues(ctx context.Context, values []interface{}, bufSize int) <-chan interface{} {
+	ch := make(chan interface{}, bufSize)
+	go func() {
+		defer close(ch)
+		for _, v := range values {
+			if ctx.Err() != nil {
+				return // Don't keep sending while the receiver is ready
+			}
+			select {
+			case ch <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// SwapValues exchanges the values of elements a and b of l. Unlike moving
+// the elements, this leaves all links in place. The exchange is atomic:
+// it write-locks both elements at once, so consistent snapshots like Slice
+// see either both old or both new values. To lock them in list order, it
+// walks l up to the later of them, so it takes time linear in its position.
+// It returns false, without changing anything, if a or b is not an element of l.
+// The elements must not be nil.
+func (l *List) SwapValues(a, b *Element) bool {
+	if a == b {
+		return l.Contains(a)
+	}
+	l.lazyInit(false)
+
+	// Serialise with other updates of the values, in a fixed order to avoid
+	// deadlock between concurrent swaps
+	first, second := a, b
+	if uintptr(unsafe.Pointer(b)) < uintptr(unsafe.Pointer(a)) {
+		first, second = b, a
+	}
+	first.valueMutex.Lock()
+	defer first.valueMutex.Unlock()
+	second.valueMutex.Lock()
+	defer second.valueMutex.Unlock()
+
+	// Walk hand over hand, read-locking the other elements, up to a and b,
+	// which stay write-locked
+	target := func(e *Element) bool { return e == a || e == b }
+	var locked [2]*Element
+	found := 0
+	e := &l.head
+	e.mutex.RLock()
+	for found < 2 && e.next != &l.tail {
+		n := e.next
+		if target(n) {
+			n.mutex.Lock()
+			locked[found] = n
+			found++
+		} else {
+			n.mutex.RLock()
+		}
+		if !target(e) {
+			e.mutex.RUnlock()
+		}
+		e = n
+	}
+	if !target(e) {
+		e.mutex.RUnlock()
+	}
+	if found == 2 {
+		a.Value, b.Value = b.Value, a.Value
+	}
+	for _, x := range locked[:found] {
+		x.mutex.Unlock()
+	}
+	return found == 2
+}
+
+// ReplaceValue sets the value of element e of l to newValue and returns its
+// previous value. Unlike assigning to e.Value, this is safe while l is
+// accessed concurrently. It returns false, without changing anything, if e
+// is not an element of l.
+// The element must not be nil.
+func (l *List) ReplaceValue(e *Element, newValue interface{}) (old interface{}, ok bool) {
+	e.valueMutex.Lock()
+	defer e.valueMutex.Unlock()
+
+	if !l.Contains(e) {
+		return nil, false
+	}
+	old = e.Value
+	e.storeValue(newValue)
+	return old, true
+}
+
+// ReplaceAll walks l from front to back and replaces each value for which
+// match returns true by the result of newValue applied to it, in place, and
+// returns the number of values replaced. Each replacement is atomic like
+// ReplaceValue, but values that concurrent updates store after the walk has
+// passed them are not matched. match and newValue are called while holding
+// the value lock of the element, so they must not update the values of l.
+func (l *List) ReplaceAll(match func(v interface{}) bool, newValue func(old interface{}) interface{}) int {
+	replaced := 0
+	for e := l.Front(); e != nil; e = e.Next() {
+		if l.replaceIf(e, match, newValue) {
+			replaced++
+		}
+	}
+	return replaced
+}
+
+// Upsert replaces the value of the first element of l whose value matches by
+// the result of update(true, old), or if there is none, pushes a new element
+// with value update(false, nil) at the back of l. Searching l and pushing are
+// atomic, as are reading and replacing the value of the element found, so
+// concurrent Upserts with the same match don't push duplicates. If matching
+// elements are inserted concurrently, the element updated may no longer be
+// the first matching one by the time it is updated. Like RotateToFront, the
+// search locks all elements of l, so it takes O(l.Len()) time and holds off
+// all other operations on l meanwhile. match and update must not access l.
+func (l *List) Upsert(match func(v interface{}) bool, update func(found bool, old interface{}) interface{}) {
+	replace := func(old interface{}) interface{} { return update(true, old) }
+	for {
+		e := l.findOrPushBack(match, update)
+		if e == nil || l.replaceIf(e, match, replace) {
+			return
+		}
+		// e was removed or changed before we could update it, try again
+	}
+}
+
+// findOrPushBack returns the first element of l whose value matches, or if
+// there is none, pushes update(false, nil) at the back of l and returns nil.
+func (l *List) findOrPushBack(match func(v interface{}) bool, update func(found bool, old interface{}) interface{}) *Element {
+	newLen := int64(-1)
+	defer l.lenChanged(&newLen) // Runs after all locks are released
+	last, _ := l.lockAll()
+	defer unlockRun(&l.head, &l.tail) // Follows the new links, which still reach all
+
+	for e := l.head.next; e != &l.tail; e = e.next {
+		if match(e.Value) {
+			return e
+		}
+	}
+	e := l.newElement(update(false, nil))
+	e.mutex.Lock()
+	l.claim(e, e)
+	last.next = e
+	e.prev = last
+	e.next = &l.tail
+	l.tail.prev = e
+	newLen = atomic.AddInt64(&l.len, 1)
+	l.raiseHighWaterMark(newLen)
+	return nil
+}
+
+// replaceIf replaces the value of element e of l by the result of newValue
+// applied to it if match returns true for it, and reports whether it did.
+func (l *List) replaceIf(e *Element, match func(v interface{}) bool, newValue func(old interface{}) interface{}) bool {
+	e.valueMutex.Lock()
+	defer e.valueMutex.Unlock()
+
+	if !l.Contains(e) || !match(e.Value) {
+		return false
+	}
+	e.storeValue(newValue(e.Value))
+	return true
+}
+
 func (l *List) copyListElements() (*Element, *Element) {
 	// TODO: Deal with modification of l during iteration
 	tmp := New()
@@ -0,0 +1,34 @@
+//go:build go1.23
+// +build go1.23
+
+package concurrent
+
+import "iter"
+
+// All returns an iterator over the elements of l from front to back, for use
+// with range. Like stepping with Next, it sees concurrent changes of l, and
+// the loop body may remove the element it was given. The iteration ends
+// early if the element following it is removed concurrently.
+func (l *List) All() iter.Seq[*Element] {
+	return func(yield func(*Element) bool) {
+		for e := l.Front(); e != nil; {
+			next := e.Next() // Before yielding, so the body may remove e
+			if !yield(e) {
+				return
+			}
+			e = next
+		}
+	}
+}
+
+// Values returns an iterator over the values of the elements of l from front
+// to back, which walks l like All.
+func (l *List) Values() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		for e := range l.All() {
+			if !yield(e.loadValue()) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+//go:build go1.23
+// +build go1.23
+
+package concurrent
+
+import "testing"
+
+func TestAll(t *testing.T) {
+	l := New()
+	es := []*Element{l.PushBack(1), l.PushBack(2), l.PushBack(3)}
+
+	var seen []*Element
+	for e := range l.All() {
+		seen = append(seen, e)
+	}
+	checkListPointers(t, l, seen)
+	if len(seen) != len(es) {
+		t.Fatalf("All yielded %d elements, want %d", len(seen), len(es))
+	}
+
+	// Early break, and removing the yielded element in the loop body
+	seen = nil
+	for e := range l.All() {
+		seen = append(seen, e)
+		if e == es[1] {
+			break
+		}
+		l.Remove(e)
+	}
+	if len(seen) != 2 || seen[0] != es[0] || seen[1] != es[1] {
+		t.Errorf("All with break yielded %v, want the first two elements", seen)
+	}
+	checkListPointers(t, l, es[1:])
+
+	for range New().All() {
+		t.Errorf("All of empty list yielded an element")
+	}
+}
+
+func TestValues(t *testing.T) {
+	l := New()
+	l.PushBack(1)
+	l.PushBack(2)
+	l.PushBack(3)
+
+	var values []interface{}
+	for v := range l.Values() {
+		values = append(values, v)
+	}
+	checkValues(t, "Values", values, []interface{}{1, 2, 3})
+
+	values = nil
+	for v := range l.Values() {
+		values = append(values, v)
+		if v == 2 {
+			break
+		}
+	}
+	checkValues(t, "Values with break", values, []interface{}{1, 2})
+}
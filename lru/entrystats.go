@@ -0,0 +1,59 @@
+package lru
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WithEntryStats makes the cache track the time of the last access and the
+// number of accesses of each entry, which EntryStats reports. Lookups through
+// Get, TryGet and RangeAndTouch that find the entry count as accesses.
+// now returns the current time, e.g. a fake clock in tests; if it is nil,
+// time.Now is used. Without this option, accesses are not tracked.
+func WithEntryStats(now func() time.Time) Option {
+	return func(c *LRU) {
+		if now == nil {
+			now = time.Now
+		}
+		c.now = now
+	}
+}
+
+// accessStats are the statistics of an entry, see WithEntryStats. Updates of
+// the entry's value store a new item, which shares the statistics.
+type accessStats struct {
+	lastAccess int64 // Unix nanoseconds; atomic, first for 64-bit alignment
+	hits       int64 // Atomic
+}
+
+// newAccessStats returns the statistics for a new entry, which is considered
+// accessed when it is inserted, or nil if the cache doesn't track them.
+func (c *LRU) newAccessStats() *accessStats {
+	if c.now == nil {
+		return nil
+	}
+	return &accessStats{lastAccess: c.now().UnixNano()}
+}
+
+// recordAccess counts an access of the entry of mapItem, if tracked.
+func (c *LRU) recordAccess(mapItem *item) {
+	if mapItem.access == nil {
+		return
+	}
+	atomic.StoreInt64(&mapItem.access.lastAccess, c.now().UnixNano())
+	atomic.AddInt64(&mapItem.access.hits, 1)
+}
+
+// EntryStats returns the time of the last access of key's entry, or of its
+// insertion if it wasn't accessed since, and the number of accesses, without
+// updating its "recently used"-ness. ok is false if key is not in the cache,
+// or the cache was not created with WithEntryStats. Updating the value of an
+// entry doesn't reset its statistics, but removing and adding it again does.
+func (c *LRU) EntryStats(key string) (lastAccess time.Time, hits int64, ok bool) {
+	mapItem, ok := c.peekItem(key)
+	if !ok || mapItem.access == nil {
+		return time.Time{}, 0, false
+	}
+	lastAccess = time.Unix(0, atomic.LoadInt64(&mapItem.access.lastAccess))
+	return lastAccess, atomic.LoadInt64(&mapItem.access.hits), true
+}
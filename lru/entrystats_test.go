@@ -0,0 +1,71 @@
+package lru
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEntryStats(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	now := func() time.Time { return clock }
+	l, err := New(2, WithEntryStats(now))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	checkStats := func(key string, wantAccess time.Time, wantHits int64) {
+		t.Helper()
+		lastAccess, hits, ok := l.EntryStats(key)
+		if !ok || !lastAccess.Equal(wantAccess) || hits != wantHits {
+			t.Errorf("EntryStats(%s) = %v, %v, %v, want %v, %v, true",
+				key, lastAccess, hits, ok, wantAccess, wantHits)
+		}
+	}
+
+	if _, _, ok := l.EntryStats("a"); ok {
+		t.Errorf("EntryStats of missing key should not be ok")
+	}
+	l.Add("a", 1)
+	checkStats("a", clock, 0)
+
+	clock = clock.Add(time.Second)
+	l.Get("a")
+	checkStats("a", clock, 1)
+	clock = clock.Add(time.Second)
+	l.TryGet("a")
+	checkStats("a", clock, 2)
+
+	// Misses, peeks and updates are not accesses, but keep the statistics
+	inserted := clock
+	clock = clock.Add(time.Second)
+	l.Get("b")
+	l.Peek("a")
+	l.Add("a", 2)
+	checkStats("a", inserted, 2)
+
+	// Evicting the entry drops its statistics
+	l.DumpOrder() // Let the update settle, so b is newer than a
+	l.Add("b", 2)
+	l.DumpOrder()
+	l.Add("c", 3)
+	l.Flush()
+	if _, _, ok := l.EntryStats("a"); ok {
+		t.Errorf("EntryStats of evicted key should not be ok")
+	}
+	checkStats("c", clock, 0)
+}
+
+func TestLRUEntryStatsDisabled(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("a", 1)
+	l.Get("a")
+	if _, _, ok := l.EntryStats("a"); ok {
+		t.Errorf("EntryStats without tracking should not be ok")
+	}
+}
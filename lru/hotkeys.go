@@ -0,0 +1,136 @@
+package lru
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Only one in hotKeySampleRate lookups is counted by a hotKeys tracker, which
+// keeps its lock off the path of most Get calls.
+const hotKeySampleRate = 8
+
+// Number of counted lookups after which a hotKeys tracker halves its counts,
+// so that they follow changes in the access pattern.
+const hotKeyWindow = 4096
+
+// KeyCount is a key with an estimate of how often it was looked up, see
+// LRU.HotKeys.
+type KeyCount struct {
+	Key   string
+	Count int64
+}
+
+// WithHotKeys tracks the most frequently looked up keys, which HotKeys
+// reports, keeping a counter for at most size keys. Lookups through Get and
+// TryGet are sampled, whether they find their key or not, and the counts
+// decay over time, so the keys that dominate recent lookups come out on top.
+// A non-positive size keeps the default of not tracking lookups.
+func WithHotKeys(size int) Option {
+	return func(c *LRU) {
+		c.hotKeys = nil
+		if size > 0 {
+			c.hotKeys = newHotKeys(size)
+		}
+	}
+}
+
+// hotKeys counts sampled lookups with the Space-Saving algorithm: once all
+// counters are taken, a key that isn't counted yet takes over the counter
+// with the lowest count, keeping that count. This overestimates the counts of
+// rare keys, but a key that is looked up often keeps its counter.
+type hotKeys struct {
+	lookups int64 // Atomic, first for 64-bit alignment
+	mutex   sync.Mutex
+	counts  map[string]int64
+	size    int
+	samples int // Counted lookups since the counts were last halved
+}
+
+func newHotKeys(size int) *hotKeys {
+	return &hotKeys{
+		counts: make(map[string]int64, size),
+		size:   size,
+	}
+}
+
+// record registers a lookup of key, which is counted if it is sampled.
+func (h *hotKeys) record(key string) {
+	if atomic.AddInt64(&h.lookups, 1)%hotKeySampleRate != 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.samples++; h.samples > hotKeyWindow {
+		h.decay()
+	}
+	if _, ok := h.counts[key]; !ok && len(h.counts) >= h.size {
+		minKey, minCount := h.min()
+		delete(h.counts, minKey)
+		h.counts[key] = minCount
+	}
+	h.counts[key]++
+}
+
+// min returns a key with the lowest count, and its count.
+// The caller must hold h.mutex, and h.counts must not be empty.
+func (h *hotKeys) min() (string, int64) {
+	first := true
+	var minKey string
+	var minCount int64
+	for key, n := range h.counts {
+		if first || n < minCount {
+			minKey, minCount = key, n
+			first = false
+		}
+	}
+	return minKey, minCount
+}
+
+// decay halves the counts, dropping the keys whose count reaches 0.
+// The caller must hold h.mutex.
+func (h *hotKeys) decay() {
+	for key, n := range h.counts {
+		if n /= 2; n == 0 {
+			delete(h.counts, key)
+		} else {
+			h.counts[key] = n
+		}
+	}
+	h.samples = 0
+}
+
+// top returns at most k of the counted keys with the highest counts, from
+// highest to lowest, with the counts scaled up to estimate all lookups.
+func (h *hotKeys) top(k int) []KeyCount {
+	h.mutex.Lock()
+	result := make([]KeyCount, 0, len(h.counts))
+	for key, n := range h.counts {
+		result = append(result, KeyCount{key, n * hotKeySampleRate})
+	}
+	h.mutex.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Key < result[j].Key
+	})
+	if k < len(result) {
+		result = result[:k]
+	}
+	return result
+}
+
+// HotKeys returns at most k of the most frequently looked up keys, from most
+// to least frequent, with estimates of their recent numbers of lookups.
+// It returns nil unless the cache was created with WithHotKeys. The estimates
+// are approximate: lookups are sampled, and keys that are looked up rarely may
+// be overestimated.
+func (c *LRU) HotKeys(k int) []KeyCount {
+	if c.hotKeys == nil || k <= 0 {
+		return nil
+	}
+	return c.hotKeys.top(k)
+}
@@ -0,0 +1,84 @@
+package lru
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// Look up n keys from l, dominated by hot: it makes up about half of the
+// lookups, the rest are spread over 1000 other keys
+func getSkewed(l *LRU, rnd *rand.Rand, hot string, n int) {
+	for i := 0; i < n; i++ {
+		if rnd.Intn(2) == 0 {
+			l.Get(hot)
+		} else {
+			l.Get(strconv.Itoa(rnd.Intn(1000)))
+		}
+	}
+}
+
+func TestLRUHotKeys(t *testing.T) {
+	l, err := New(128, WithHotKeys(16))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	if hot := l.HotKeys(3); len(hot) != 0 {
+		t.Errorf("HotKeys before any lookups = %v, want none", hot)
+	}
+
+	l.Add("a", 1)
+	rnd := rand.New(rand.NewSource(1))
+	const n = 20000
+	getSkewed(l, rnd, "a", n)
+	hot := l.HotKeys(3)
+	if len(hot) != 3 || hot[0].Key != "a" {
+		t.Fatalf("HotKeys(3) = %v, want a first", hot)
+	}
+	if hot[0].Count < n/4 || hot[0].Count > n {
+		t.Errorf("bad count estimate for a: %v, want about %v", hot[0].Count, n/2)
+	}
+	for i := 1; i < len(hot); i++ {
+		if hot[i].Count > hot[i-1].Count {
+			t.Errorf("HotKeys(3) = %v, not sorted by count", hot)
+		}
+	}
+
+	// The counts decay, so a new hot key takes over
+	getSkewed(l, rnd, "b", 10*hotKeySampleRate*hotKeyWindow)
+	if hot := l.HotKeys(1); len(hot) != 1 || hot[0].Key != "b" {
+		t.Errorf("HotKeys(1) after the shift = %v, want b", hot)
+	}
+
+	if hot := l.HotKeys(100); len(hot) > 16 {
+		t.Errorf("HotKeys(100) returned %d keys, more than tracked", len(hot))
+	}
+}
+
+func TestLRUHotKeysDisabled(t *testing.T) {
+	l, err := New(128)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("a", 1)
+	l.Get("a")
+	if hot := l.HotKeys(1); hot != nil {
+		t.Errorf("HotKeys without tracking = %v, want nil", hot)
+	}
+}
+
+func BenchmarkLRU_GetHotKeysParallel(b *testing.B) {
+	l, _ := New(128, WithHotKeys(16))
+	defer l.Close()
+	l.Add("a", 1)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.Get("a")
+		}
+	})
+}
@@ -11,6 +11,7 @@
 package lru
 
 import (
+	"runtime"
 	"sync"
 	"sync/atomic"
 )
@@ -26,6 +27,10 @@ type element struct {
 	// A mutex protects all accesses to the list
 	mutex sync.Mutex
 
+	// Sequence number of the last insertion of this element in strict mode,
+	// see stamp. Protected by mutex.
+	seq uint64
+
 	// The value stored with this element.
 	Value interface{}
 }
@@ -41,17 +46,46 @@ type list struct {
 	// Fixed size because of atomic access
 	len                int64
 	nPendingInsertions int64
+	lastSeq            uint64 // In strict mode, the last sequence number, see stamp
 
 	pendingInsertions chan *element
 	workers           sync.WaitGroup
+
+	// Moves scheduled by TryMoveToFront, performed by the mover worker.
+	// They are counted in nPendingInsertions until complete.
+	pendingMoves chan *element
+	movers       sync.WaitGroup
+
+	// Set by a PopBack that keeps losing races against MoveToFront, which then
+	// holds popFallback for writing to hold off new moves until it succeeds.
+	popStarving int32
+	popFallback sync.RWMutex
+
+	// Attempts PopBack makes before holding off concurrent moves, normally
+	// popBackAttempts. Tests lower it to reach the fallback sooner.
+	popAttempts int
+
+	// If set, MoveToFront of an element whose insertion is pending waits for
+	// it to complete and then moves the element, rather than leaving it at
+	// the position it was enqueued at. Set before first use.
+	strictMoves bool
+
+	// If set, called whenever a strict MoveToFront waits for the pending
+	// insertion of its element. Set before first use; meant for tests.
+	onWaitForInsertion func()
 }
 
+// Number of attempts PopBack makes before holding off concurrent moves
+const popBackAttempts = 8
+
 // New returns an initialized list. Always create LRUList through New().
 func newList() *list {
 	l := new(list)
 	l.len = 0
 	l.nPendingInsertions = 0
 	l.pendingInsertions = make(chan *element, 128)
+	l.pendingMoves = make(chan *element, 128)
+	l.popAttempts = popBackAttempts
 
 	l.head.prev = nil
 	l.head.list = l
@@ -63,10 +97,16 @@ func newList() *list {
 
 	l.workers.Add(1)
 	go l.frontInserter()
+	l.movers.Add(1)
+	go l.mover()
 	return l
 }
 
 func (l *list) Close() {
+	// Stop the mover first, its moves still need the insertion workers
+	close(l.pendingMoves)
+	l.movers.Wait()
+
 	// Stop the insertion workers
 	close(l.pendingInsertions)
 	l.workers.Wait()
@@ -76,19 +116,30 @@ func (l *list) Close() {
 // The complexity is O(1).
 func (l *list) Len() int { return int(atomic.LoadInt64(&l.len)) }
 
-// insertFront inserts e at the front of l.
+// insertFront inserts e at the front of l. In strict mode, it inserts e
+// behind the elements at the front with a later sequence number instead, so
+// that l stays ordered by sequence number, see stamp.
 func (l *list) insertFront(e *element) {
-	h := &l.head
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
-	e.mutex.Lock()
-	defer e.mutex.Unlock()
-	n := h.next
+	p := &l.head
+	p.mutex.Lock()
+	n := p.next
 	n.mutex.Lock()
+	if l.strictMoves {
+		// Usually none or few, which were stamped after e but enqueued before
+		for n != &l.tail && n.seq > e.seq {
+			n.next.mutex.Lock()
+			p.mutex.Unlock()
+			p, n = n, n.next
+		}
+	}
+	defer p.mutex.Unlock()
 	defer n.mutex.Unlock()
+	// e is not in l, so nobody else waits for it while holding p or n
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 
-	h.next = e
-	e.prev = h
+	p.next = e
+	e.prev = p
 	e.next = n
 	n.prev = e
 	e.list = l
@@ -96,6 +147,27 @@ func (l *list) insertFront(e *element) {
 	atomic.AddInt64(&l.nPendingInsertions, -1)
 }
 
+// enqueueInsertion schedules the insertion of e at the front of l, which the
+// caller must count in nPendingInsertions.
+func (l *list) enqueueInsertion(e *element) {
+	l.stamp(e)
+	l.pendingInsertions <- e
+}
+
+// stamp gives e the next sequence number in strict mode. Concurrent
+// insertions may be enqueued in another order than they were stamped in, but
+// insertFront inserts them in the order of their sequence numbers, so the
+// earlier of them ends up closer to the back.
+func (l *list) stamp(e *element) {
+	if !l.strictMoves {
+		return
+	}
+	seq := atomic.AddUint64(&l.lastSeq, 1)
+	e.mutex.Lock()
+	e.seq = seq
+	e.mutex.Unlock()
+}
+
 // Asynchronous front insertion worker
 func (l *list) frontInserter() {
 	defer l.workers.Done()
@@ -105,6 +177,16 @@ func (l *list) frontInserter() {
 	}
 }
 
+// Asynchronous worker for the moves scheduled by TryMoveToFront
+func (l *list) mover() {
+	defer l.movers.Done()
+
+	for e := range l.pendingMoves {
+		l.MoveToFront(e)
+		atomic.AddInt64(&l.nPendingInsertions, -1)
+	}
+}
+
 // Returns the predecessor of e in l in a thread safe way.
 // The returned element, if not nil, is locked for writing.
 func predecessor(e *element) *element {
@@ -160,25 +242,226 @@ func (l *list) remove(e *element, validateList bool, newList *list) (*element, b
 }
 
 // PopBack removes the last element from l if l is not empty.
-// It returns the element value e.Value.
+// It returns the removed element, or nil if l is empty.
+// If it repeatedly loses races against concurrent moves of the last element,
+// it briefly blocks new MoveToFront calls on l to ensure progress.
 func (l *list) PopBack() *element {
+	for i := 0; i < l.popAttempts; i++ {
+		if e, ok := l.popBack(); ok {
+			return e
+		}
+	}
+
+	l.popFallback.Lock()
+	atomic.StoreInt32(&l.popStarving, 1)
+	defer l.popFallback.Unlock()
+	defer atomic.StoreInt32(&l.popStarving, 0)
+	for {
+		// Moves that are already in progress can still beat us, but new ones wait
+		if e, ok := l.popBack(); ok {
+			return e
+		}
+		// Let the moves in progress finish rather than spin against them
+		runtime.Gosched()
+	}
+}
+
+// popBack makes one attempt to remove the last element from l.
+// It returns the removed element, or nil if l is empty, and false if the
+// attempt lost a race against a concurrent removal or move.
+func (l *list) popBack() (*element, bool) {
 	e := predecessor(&l.tail)
 	e.mutex.Unlock()
 	if e == &l.head {
-		return nil // list empty. Note: async insertions can still be pending
+		return nil, true // list empty. Note: async insertions can still be pending
 	}
 	if _, ok := l.remove(e, true, nil); ok {
-		return e
+		return e, true
 	}
-	return nil
+	return nil, false
+}
+
+// PopBackN removes up to n elements from the back of l in one traversal.
+// It returns the removed elements from back to front, which is fewer than n
+// only if l holds fewer elements. Like PopBack, it briefly blocks new
+// MoveToFront calls on l if it repeatedly loses races against them.
+func (l *list) PopBackN(n int) []*element {
+	if n <= 0 {
+		return nil
+	}
+	for i := 0; i < l.popAttempts; i++ {
+		if es, ok := l.popBackN(n); ok {
+			return es
+		}
+	}
+
+	l.popFallback.Lock()
+	atomic.StoreInt32(&l.popStarving, 1)
+	defer l.popFallback.Unlock()
+	defer atomic.StoreInt32(&l.popStarving, 0)
+	for {
+		// Moves that are already in progress can still beat us, but new ones wait
+		if es, ok := l.popBackN(n); ok {
+			return es
+		}
+		// Let the moves in progress finish rather than spin against them
+		runtime.Gosched()
+	}
+}
+
+// popBackN makes one attempt to remove up to n elements from the back of l.
+// It returns false if the attempt lost a race against a concurrent removal
+// or move.
+func (l *list) popBackN(n int) ([]*element, bool) {
+	// Find the first element to remove by stepping back from the tail
+	first := &l.tail
+	for i := 0; i < n && first != &l.head; i++ {
+		p := predecessor(first)
+		if p == nil {
+			return nil, false // first was removed while we stepped
+		}
+		p.mutex.Unlock()
+		first = p
+	}
+	if first == &l.tail {
+		return nil, true
+	}
+
+	// Lock the run from its predecessor to the tail, always head-to-tail
+	p := first
+	if first != &l.head {
+		if p = predecessor(first); p == nil {
+			return nil, false
+		}
+	} else {
+		p.mutex.Lock()
+	}
+	count := 0
+	last := p
+	for e := p.next; ; e = e.next {
+		e.mutex.Lock()
+		last = e
+		if e == &l.tail {
+			break
+		}
+		if count++; count > n {
+			// first was moved to the front, so the run is not at the back
+			unlockRun(p, last)
+			return nil, false
+		}
+	}
+	if count == 0 {
+		unlockRun(p, last)
+		return nil, true // list empty. Note: async insertions can still be pending
+	}
+
+	es := make([]*element, count)
+	for i, e := 0, l.tail.prev; i < count; i, e = i+1, e.prev {
+		es[i] = e
+	}
+	p.next = &l.tail
+	l.tail.prev = p
+	atomic.AddInt64(&l.len, -int64(count))
+	for _, e := range es {
+		e.next = nil
+		e.prev = nil
+		e.list = nil
+	}
+	unlockRun(p, last)
+	for _, e := range es {
+		e.mutex.Unlock()
+	}
+	return es, true
+}
+
+// PopBackUnless removes the element closest to the back of l for which skip
+// returns false, and returns it, or nil if there is none. It also reports
+// whether skip returned true for any element. skip sees each element while it
+// is locked and in l, and must not access l. Unlike PopBack, it walks past the
+// skipped elements, so it takes time linear in their number.
+func (l *list) PopBackUnless(skip func(e *element) bool) (*element, bool) {
+	for {
+		var victim *element
+		skipped := false
+		l.eachBack(func(e *element) bool {
+			if skip(e) {
+				skipped = true
+				return true
+			}
+			victim = e
+			return false
+		})
+		if victim == nil {
+			return nil, skipped
+		}
+		if _, ok := l.remove(victim, true, nil); ok {
+			return victim, skipped
+		}
+		// Lost a race against a concurrent removal or move, try again
+	}
+}
+
+// unlockRun unlocks the elements from first to last, following next.
+func unlockRun(first, last *element) {
+	for e := first; ; {
+		next := e.next // Read before unlocking, next is still locked by us
+		e.mutex.Unlock()
+		if e == last {
+			return
+		}
+		e = next
+	}
+}
+
+// each calls f for each element of l, from front to back. It walks the list
+// hand over hand, so f sees each element while it is locked and in l, but
+// the list as a whole may change during the walk.
+// f must not access l.
+func (l *list) each(f func(e *element)) {
+	e := &l.head
+	e.mutex.Lock()
+	for n := e.next; n != &l.tail; n = e.next {
+		n.mutex.Lock()
+		e.mutex.Unlock()
+		f(n)
+		e = n
+	}
+	e.mutex.Unlock()
+}
+
+// eachBack calls f for the elements of l from back to front, until f returns
+// false. Like each, f sees each element while it is locked and in l, and
+// must not access l. The walk ends early if the element it stepped back from
+// is removed or moved concurrently.
+func (l *list) eachBack(f func(e *element) bool) {
+	for e := &l.tail; ; {
+		p := predecessor(e)
+		if p == nil {
+			return
+		}
+		if p == &l.head || !f(p) {
+			p.mutex.Unlock()
+			return
+		}
+		p.mutex.Unlock()
+		e = p
+	}
+}
+
+// Contains reports whether e is an element of l, including when its
+// insertion into l is still pending.
+func (l *list) Contains(e *element) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.list == l
 }
 
 // PushFront inserts a new element e with value v at the front of list l and returns e.
 func (l *list) PushFront(v interface{}) *element {
-	e := &element{Value: v}
+	e := &element{Value: v, list: l}
 	atomic.AddInt64(&l.len, 1)
 	atomic.AddInt64(&l.nPendingInsertions, 1)
-	l.pendingInsertions <- e
+	l.enqueueInsertion(e)
 	return e
 }
 
@@ -186,12 +469,74 @@ func (l *list) PushFront(v interface{}) *element {
 // It is allowed to move an element not in l through MoveToFront().
 // The element must not be nil.
 func (l *list) MoveToFront(e *element) bool {
-	_, ok := l.remove(e, false, l)
-	if ok {
-		atomic.AddInt64(&l.nPendingInsertions, 1)
-		l.pendingInsertions <- e
+	if atomic.LoadInt32(&l.popStarving) != 0 {
+		// Let a starving PopBack make progress first
+		l.popFallback.RLock()
+		l.popFallback.RUnlock()
+	}
+
+	for {
+		_, ok := l.remove(e, false, l)
+		if ok {
+			atomic.AddInt64(&l.nPendingInsertions, 1)
+			l.enqueueInsertion(e)
+			return true
+		}
+		if !l.strictMoves {
+			// If someone else is already moving e to front of l, that's also fine
+			return l.Contains(e)
+		}
+
+		// Entries enqueued after e but before this move would end up in front
+		// of it, so wait for e to be inserted, then move it again
+		e.mutex.Lock()
+		inList, pending := e.list == l, e.prev == nil
+		e.mutex.Unlock()
+		if !inList {
+			return false
+		}
+		if pending {
+			if l.onWaitForInsertion != nil {
+				l.onWaitForInsertion()
+			}
+			runtime.Gosched()
+		}
+	}
+}
+
+// Remove removes e from l and reports whether it did. If the insertion of e
+// is still pending, it waits for it to complete first. It returns false if e
+// is not in l, e.g. because it was popped.
+// The element must not be nil.
+func (l *list) Remove(e *element) bool {
+	for {
+		if _, ok := l.remove(e, true, nil); ok {
+			return true
+		}
+		e.mutex.Lock()
+		inList, pending := e.list == l, e.prev == nil
+		e.mutex.Unlock()
+		if !inList {
+			return false
+		}
+		if pending {
+			runtime.Gosched()
+		}
+	}
+}
+
+// TryMoveToFront schedules a move of e to the front of l without blocking,
+// and reports whether it did. It returns false if too many moves are already
+// scheduled, e.g. because they are slowed down by contention on the list.
+// The move itself behaves like MoveToFront, so e may be gone by then.
+// The element must not be nil.
+func (l *list) TryMoveToFront(e *element) bool {
+	atomic.AddInt64(&l.nPendingInsertions, 1)
+	select {
+	case l.pendingMoves <- e:
 		return true
+	default:
+		atomic.AddInt64(&l.nPendingInsertions, -1)
+		return false
 	}
-	// If someone else is already moving e to front of l, that's also fine
-	return e.list == l
 }
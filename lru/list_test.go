@@ -11,6 +11,7 @@
 package lru
 
 import (
+	"runtime"
 	"sync/atomic"
 	"testing"
 )
@@ -29,6 +30,7 @@ func (l *list) waitForInsertions() {
 		return atomic.LoadInt64(&l.nPendingInsertions)
 	}
 	for p := lp(); p > 0; p = lp() {
+		runtime.Gosched()
 	}
 }
 
@@ -188,3 +190,144 @@ func TestMoveBetweenLists(t *testing.T) {
 	checkListPointers(t, l2, []*element{e4, e3, e2})
 	checkListPointers(t, l1, []*element{e1})
 }
+
+func TestPopBackN(t *testing.T) {
+	l := newList()
+	defer l.Close()
+	e1 := l.PushFront(1)
+	e2 := l.PushFront(2)
+	e3 := l.PushFront(3)
+	e4 := l.PushFront(4)
+	checkListPointers(t, l, []*element{e4, e3, e2, e1})
+
+	if es := l.PopBackN(0); len(es) != 0 {
+		t.Errorf("PopBackN(0) returned %v, expected none", es)
+	}
+	es := l.PopBackN(2)
+	if len(es) != 2 || es[0] != e1 || es[1] != e2 {
+		t.Errorf("PopBackN(2) returned %v, expected [%p %p]", es, e1, e2)
+	}
+	checkListPointers(t, l, []*element{e4, e3})
+	if l.Contains(e1) || l.Contains(e2) {
+		t.Errorf("popped elements still in list")
+	}
+
+	// More than the list holds
+	es = l.PopBackN(5)
+	if len(es) != 2 || es[0] != e3 || es[1] != e4 {
+		t.Errorf("PopBackN(5) returned %v, expected [%p %p]", es, e3, e4)
+	}
+	checkListPointers(t, l, []*element{})
+	if es := l.PopBackN(1); len(es) != 0 {
+		t.Errorf("PopBackN on empty list returned %v", es)
+	}
+}
+
+func TestEachBack(t *testing.T) {
+	l := newList()
+	defer l.Close()
+	e1 := l.PushFront(1)
+	e2 := l.PushFront(2)
+	e3 := l.PushFront(3)
+	checkListPointers(t, l, []*element{e3, e2, e1})
+
+	collect := func(n int) []*element {
+		var es []*element
+		l.eachBack(func(e *element) bool {
+			es = append(es, e)
+			return len(es) < n
+		})
+		return es
+	}
+	if es := collect(2); len(es) != 2 || es[0] != e1 || es[1] != e2 {
+		t.Errorf("eachBack stopping after 2 saw %v, expected [%p %p]", es, e1, e2)
+	}
+	if es := collect(5); len(es) != 3 || es[2] != e3 {
+		t.Errorf("eachBack saw %v, expected all 3 elements", es)
+	}
+	l.PopBackN(3)
+	if es := collect(1); len(es) != 0 {
+		t.Errorf("eachBack on empty list saw %v", es)
+	}
+}
+
+func TestPopBackUnless(t *testing.T) {
+	l := newList()
+	defer l.Close()
+	e1 := l.PushFront(1)
+	e2 := l.PushFront(2)
+	e3 := l.PushFront(3)
+	checkListPointers(t, l, []*element{e3, e2, e1})
+
+	odd := func(e *element) bool { return e.Value.(int)%2 == 1 }
+	if e, skipped := l.PopBackUnless(odd); e != e2 || !skipped {
+		t.Errorf("PopBackUnless(odd) = %p, %v, expected %p, true", e, skipped, e2)
+	}
+	checkListPointers(t, l, []*element{e3, e1})
+	if e, skipped := l.PopBackUnless(odd); e != nil || !skipped {
+		t.Errorf("PopBackUnless(odd) with only odd values = %p, %v, expected nil, true", e, skipped)
+	}
+	checkListPointers(t, l, []*element{e3, e1})
+
+	none := func(e *element) bool { return false }
+	if e, skipped := l.PopBackUnless(none); e != e1 || skipped {
+		t.Errorf("PopBackUnless(none) = %p, %v, expected %p, false", e, skipped, e1)
+	}
+	l.PopBack()
+	if e, skipped := l.PopBackUnless(none); e != nil || skipped {
+		t.Errorf("PopBackUnless on empty list = %p, %v, expected nil, false", e, skipped)
+	}
+}
+
+func TestStrictInsertionOrder(t *testing.T) {
+	l := newList()
+	l.strictMoves = true
+	defer l.Close()
+	e1 := l.PushFront(1)
+	e2 := l.PushFront(2)
+	checkListPointers(t, l, []*element{e2, e1})
+
+	// Stamped in one order but enqueued in the other, as by concurrent
+	// insertions that are preempted in between
+	e3 := &element{Value: 3, list: l}
+	e4 := &element{Value: 4, list: l}
+	l.stamp(e3)
+	l.stamp(e4)
+	atomic.AddInt64(&l.len, 2)
+	atomic.AddInt64(&l.nPendingInsertions, 2)
+	l.pendingInsertions <- e4
+	l.pendingInsertions <- e3
+	checkListPointers(t, l, []*element{e4, e3, e2, e1})
+
+	// A move is stamped anew
+	l.MoveToFront(e1)
+	checkListPointers(t, l, []*element{e1, e4, e3, e2})
+	if es := l.PopBackN(2); len(es) != 2 || es[0] != e2 || es[1] != e3 {
+		t.Errorf("PopBackN(2) returned %v, expected [%p %p]", es, e2, e3)
+	}
+}
+
+func benchmarkPopBack(b *testing.B, bulk bool) {
+	const burst = 1024
+	l := newList()
+	defer l.Close()
+	for i := 0; i < b.N; i += burst {
+		b.StopTimer()
+		for j := 0; j < burst; j++ {
+			l.PushFront(j)
+		}
+		l.waitForInsertions()
+		b.StartTimer()
+
+		if bulk {
+			l.PopBackN(burst)
+		} else {
+			for j := 0; j < burst; j++ {
+				l.PopBack()
+			}
+		}
+	}
+}
+
+func BenchmarkPopBack(b *testing.B)  { benchmarkPopBack(b, false) }
+func BenchmarkPopBackN(b *testing.B) { benchmarkPopBack(b, true) }
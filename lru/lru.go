@@ -2,8 +2,12 @@ package lru
 
 import (
 	"errors"
+	"fmt"
+	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/golang-lru/simplelru"
 	cmap "github.com/orcaman/concurrent-map"
@@ -11,13 +15,184 @@ import (
 
 // LRU is a thread-safe least-recently used cache
 type LRU struct {
-	capacity int
-	len      int64              // Fixed size because of atomic access
-	items    cmap.ConcurrentMap // TODO: This only accepts string keys because of hashing
+	capacity int64                // Fixed size because of atomic access
+	len      int64                // Fixed size because of atomic access
+	evicting int64                // Evictions in progress, see Flush
+	nPins    int64                // Number of pinned keys, see Pin
+	stats    stats                // Atomic counters, first for 64-bit alignment
+	items    []cmap.ConcurrentMap // TODO: Only string keys so far. Sharded by keyHash
 	evict    *list
 	onEvict  simplelru.EvictCallback
 	cleanup  sync.Cond
 	workers  sync.WaitGroup
+	flushed  sync.Cond // Broadcast when evicting drops to zero, see Flush
+
+	// Entries allowed beyond capacity before Add evicts synchronously.
+	// Negative means unbounded: only the cleanup worker evicts.
+	maxOvershoot int
+
+	// Receives panics recovered from onEvict
+	onEvictPanic func(key string, recovered interface{})
+
+	// If set through WithEvictPool, the evictPool goroutines call onEvict
+	// for the evicted items sent to evictQueue
+	evictQueue chan *item
+	evictPool  sync.WaitGroup
+
+	// Called for each newly inserted entry
+	onInsert func(key string, value interface{})
+
+	// Distributes keys over the shards of items
+	keyHash func(key interface{}) uint32
+
+	// Number of entries the cleanup worker evicts at once if positive,
+	// otherwise it adapts the number to the overshoot, see evictBatchSize
+	evictBatch int
+
+	// If set through NewWithPolicy, decides the evictions instead of evict.
+	// policyMutex serialises its use together with the map updates.
+	policy      EvictionPolicy
+	policyMutex sync.Mutex
+
+	// If set through WithSpillHandler, receives the entries evicted to make
+	// room before they are dropped
+	spill func(key string, value interface{}) error
+
+	// If set through WithHotKeys, counts the lookups of keys
+	hotKeys *hotKeys
+
+	// If set through NewSyncEvict, insertions evict synchronously and wait
+	// for the eviction callbacks
+	syncEvict bool
+
+	// If set through WithEntryStats, the clock for the access statistics of
+	// the entries
+	now func() time.Time
+
+	// Keys marked by Pin, which evictions skip
+	pins     map[string]struct{}
+	pinMutex sync.RWMutex
+
+	// evictEpoch counts the changes that may let blocked evictions proceed,
+	// like insertions. pinBlocked is the evictEpoch at which an eviction last
+	// found only pinned entries left, see blockedByPins. Both are atomic and
+	// only written to while holding cleanup.L, except pinBlocked.
+	evictEpoch int64
+	pinBlocked int64
+}
+
+// Upper bound on the number of entries the cleanup worker evicts at once,
+// which bounds how long it holds the elements at the back of the evict list.
+const maxEvictBatch = 64
+
+// Number of maps that LRU.items is sharded into. Each of them is sharded
+// again internally, so a few suffice to let keyHash spread out hot keys.
+const keyShardCount = 16
+
+// Counters reported through LRU.Collect
+type stats struct {
+	hits, misses, evictions, spillErrors int64
+}
+
+// Metrics is a snapshot of the counters of an LRU cache.
+type Metrics struct {
+	Len               int   // Number of entries, see LRU.Len
+	Cap               int   // Capacity, see LRU.Cap
+	Hits              int64 // Number of Get calls that found their key
+	Misses            int64 // Number of Get calls that did not
+	Evictions         int64 // Number of entries evicted since creation
+	PendingInsertions int64 // Number of recency updates in progress
+	SpillErrors       int64 // Number of errors returned by the spill handler
+}
+
+// Collector is implemented by caches that report Metrics, for use in
+// adapters to monitoring systems.
+type Collector interface {
+	Collect() Metrics
+}
+
+// Option configures optional behaviour of an LRU cache.
+type Option func(*LRU)
+
+// WithMaxOvershoot bounds how far the cache may temporarily exceed its
+// capacity while the cleanup worker catches up. If an Add would take the
+// cache beyond capacity+n entries, it first evicts synchronously to make
+// room, so that the cache stays within the bound also under concurrent Adds,
+// unless only pinned entries are left to evict.
+// A negative n disables the bound, which is the default.
+func WithMaxOvershoot(n int) Option {
+	return func(c *LRU) {
+		c.maxOvershoot = n
+	}
+}
+
+// WithEvictPanicHandler sets a handler for panics in the eviction callback.
+// Such panics are always recovered, so that they don't stop evictions,
+// and then passed to handler together with the key of the evicted entry.
+func WithEvictPanicHandler(handler func(key string, recovered interface{})) Option {
+	return func(c *LRU) {
+		c.onEvictPanic = handler
+	}
+}
+
+// WithEvictPool calls the eviction callback from a pool of n goroutines,
+// rather than from the goroutine that evicts, so that slow callbacks run
+// concurrently and don't hold up evictions. Evictions only wait for the
+// callbacks if more than n are queued. Callbacks for different entries may
+// run out of order. A non-positive n keeps the default of calling inline.
+func WithEvictPool(n int) Option {
+	return func(c *LRU) {
+		c.evictQueue = nil
+		if n > 0 {
+			c.evictQueue = make(chan *item, n)
+		}
+	}
+}
+
+// WithStrictEviction makes the cache evict in strict recency order if strict
+// is set. Insertions into the eviction order are asynchronous, so by default,
+// using an entry whose insertion is still pending leaves it behind entries
+// that were added after it but before its use, which can then outlive it.
+// In strict mode, such uses wait for the insertion to complete, and entries
+// added or used at the same time enter the eviction order in the order of a
+// sequence number stamped when they are added or used, so the earlier one is
+// evicted first. This comes at some cost in latency under heavy load.
+func WithStrictEviction(strict bool) Option {
+	return func(c *LRU) {
+		c.evict.strictMoves = strict
+	}
+}
+
+// WithInsertCallback sets a callback for each insertion of a new entry.
+// It is not called when the value or recency of an existing entry is updated.
+func WithInsertCallback(onInsert func(key string, value interface{})) Option {
+	return func(c *LRU) {
+		c.onInsert = onInsert
+	}
+}
+
+// WithKeyHash sets the hash function that distributes keys over the shards
+// of the cache's map, e.g. to spread out keys that would otherwise collide.
+// The default is FNV-1a over the string representation of the key.
+func WithKeyHash(hash func(key interface{}) uint32) Option {
+	return func(c *LRU) {
+		c.keyHash = hash
+	}
+}
+
+// fnvKeyHash is the default key hash, FNV-1a over the string form of key.
+// It differs from the FNV-1 used within the shards, to decorrelate them.
+func fnvKeyHash(key interface{}) uint32 {
+	s, ok := key.(string)
+	if !ok {
+		s = fmt.Sprint(key)
+	}
+	hash := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		hash ^= uint32(s[i])
+		hash *= 16777619
+	}
+	return hash
 }
 
 // Item is the value type of an LRU.items map
@@ -25,38 +200,99 @@ type item struct {
 	key          string
 	value        interface{}
 	evictElement *element
+	access       *accessStats // If tracked, see WithEntryStats
 }
 
 // New creates an LRU of the given size.
-func New(size int) (*LRU, error) {
-	return NewWithEvict(size, nil)
+func New(size int, opts ...Option) (*LRU, error) {
+	return NewWithEvict(size, nil, opts...)
 }
 
-// NewWithEvict returns an initialized empty LRU cache with an eviction callback
-func NewWithEvict(size int, onEvict simplelru.EvictCallback) (*LRU, error) {
+// NewWithEvict returns an initialized empty LRU cache with an eviction callback.
+// A panic in onEvict is recovered and ignored, unless a handler is set through
+// WithEvictPanicHandler.
+func NewWithEvict(size int, onEvict simplelru.EvictCallback, opts ...Option) (*LRU, error) {
 	if size <= 0 {
 		return nil, errors.New("must provide a positive size")
 	}
 
 	c := &LRU{
-		capacity: size,
-		len:      0,
-		items:    cmap.New(),
-		evict:    newList(),
-		onEvict:  onEvict,
-		cleanup:  *sync.NewCond(new(sync.Mutex)),
+		capacity:     int64(size),
+		len:          0,
+		items:        make([]cmap.ConcurrentMap, keyShardCount),
+		evict:        newList(),
+		onEvict:      onEvict,
+		cleanup:      *sync.NewCond(new(sync.Mutex)),
+		flushed:      *sync.NewCond(new(sync.Mutex)),
+		maxOvershoot: -1,
+		keyHash:      fnvKeyHash,
+		pins:         make(map[string]struct{}),
+		pinBlocked:   -1,
+	}
+	for i := range c.items {
+		c.items[i] = cmap.New()
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
 
+	if c.evictQueue != nil {
+		for i := 0; i < cap(c.evictQueue); i++ {
+			c.evictPool.Add(1)
+			go c.evictWorker()
+		}
+	}
 	c.workers.Add(1)
 	go c.cleanupWorker() // always run a cleanup worker in the background
 	return c, nil
 }
 
-// Close releases the resources used by an LRU cache
+// NewWithPolicy returns an initialized empty cache that evicts the entries
+// chosen by policy, rather than the least recently used ones.
+// To keep policies like LFU from evicting new entries right away, inserting
+// into a full cache evicts synchronously, before the new entry is recorded.
+// Unlike the default eviction order, the policy is not concurrent: all
+// insertions, lookups and evictions are serialised while they use it.
+// Keys, DumpOrder and String only report the default eviction order, so
+// they report no entries for such a cache.
+func NewWithPolicy(size int, policy EvictionPolicy, onEvict simplelru.EvictCallback, opts ...Option) (*LRU, error) {
+	withPolicy := func(c *LRU) {
+		c.policy = policy
+	}
+	return NewWithEvict(size, onEvict, append(opts, withPolicy)...)
+}
+
+// NewSyncEvict returns an initialized empty cache that evicts synchronously:
+// when Add or one of its variants takes the cache over capacity, it evicts
+// the excess entries itself and waits for their eviction callbacks to
+// complete before returning. So by the time Add reports an eviction, onEvict
+// has run for the evicted entry. Add may also wait for the callbacks of
+// evictions caused by concurrent insertions. WithEvictPool has no effect on
+// such a cache, since the callbacks are always waited for.
+func NewSyncEvict(size int, onEvict simplelru.EvictCallback, opts ...Option) (*LRU, error) {
+	withSyncEvict := func(c *LRU) {
+		c.syncEvict = true
+		c.evictQueue = nil
+	}
+	return NewWithEvict(size, onEvict, append(opts, withSyncEvict)...)
+}
+
+// ErrClosed is returned by operations on an LRU cache that has been closed.
+var ErrClosed = errors.New("cache is closed")
+
+// Close releases the resources used by an LRU cache, evicting all entries.
+// Afterwards, the cache stays empty: Add and its variants don't insert
+// anything, and SetCapacity returns ErrClosed. Calling Close again does
+// nothing, but it must not be called concurrently with other operations.
 func (c *LRU) Close() {
 	// Causes the cleanup workers to remove all entries, then exit
 	c.cleanup.L.Lock()
-	c.capacity = 0
+	if c.closed() {
+		c.cleanup.L.Unlock()
+		return
+	}
+	atomic.StoreInt64(&c.capacity, 0)
+	c.evictionsUnblocked() // Pins are ignored once closed
 	c.cleanup.Broadcast()
 	c.cleanup.L.Unlock()
 
@@ -64,6 +300,16 @@ func (c *LRU) Close() {
 
 	// Return only when all workers are stopped
 	c.workers.Wait()
+	if c.evictQueue != nil {
+		close(c.evictQueue)
+		c.evictPool.Wait()
+	}
+}
+
+// closed reports whether Close has been called. It sets the capacity to 0,
+// which is not a valid capacity otherwise.
+func (c *LRU) closed() bool {
+	return c.Cap() == 0
 }
 
 func (c *LRU) cleanupWorker() {
@@ -75,49 +321,246 @@ func (c *LRU) cleanupWorker() {
 		c.cleanup.L.Unlock()
 
 		// Under heavy load, operate lock free (at least for the cleanup mutex)
-		for n := c.Len(); n > c.capacity; n = c.Len() {
-			// Claim one eviction by decrementing the counter
-			if !atomic.CompareAndSwapInt64(&c.len, int64(n), int64(n-1)) {
-				continue // Claim failed, try again
-			}
-
-			popElement := c.evict.PopBack()
-			if popElement == nil {
-				// Pop failed; return claimed eviction, try again
-				atomic.AddInt64(&c.len, 1)
-			} else {
-				popItem := popElement.Value.(*item)
-				c.items.RemoveCb(popItem.key,
-					func(key string, v interface{}, exists bool) bool {
-						// Check that the map entry was not replaced in the meantime
-						if !exists {
-							return false
-						}
-						return v.(*item) == popItem
-					})
-				if c.onEvict != nil {
-					c.onEvict(popItem.key, popItem.value)
-				}
-				popElement.Value = nil
-				popItem.evictElement = nil
-			}
-
+		for c.evictExcess(c.Cap()) {
 		}
 
 		// Perform one final check under lock before we go to sleep or exit
 		c.cleanup.L.Lock()
-		if c.Len() > c.capacity {
+		if c.Len() > c.Cap() && !c.blockedByPins() {
 			continue // Someone inserted something before we locked, carry on
-		} else if c.capacity > 0 {
+		} else if !c.closed() {
 			// Wait for something to clean up
 			c.cleanup.Wait()
 		} else {
-			// Capacity is set to 0 in Close()
 			return
 		}
 	}
 }
 
+// shard returns the map that holds key.
+func (c *LRU) shard(key string) cmap.ConcurrentMap {
+	return c.items[c.keyHash(key)%uint32(len(c.items))]
+}
+
+// evictOldest evicts the least recently used entry if the cache holds more
+// than limit entries. It returns the evicted item, if any, and whether the
+// cache was over the limit, in which case the caller may want to try again.
+func (c *LRU) evictOldest(limit int) (*item, bool) {
+	// Count the eviction as in progress before claiming it, see Flush
+	atomic.AddInt64(&c.evicting, 1)
+	defer c.evictionDone()
+
+	n := c.Len()
+	if n <= limit {
+		return nil, false
+	}
+
+	// Claim one eviction by decrementing the counter
+	if !atomic.CompareAndSwapInt64(&c.len, int64(n), int64(n-1)) {
+		return nil, true // Claim failed, try again
+	}
+
+	if c.policy != nil {
+		evicted := c.evictVictim()
+		if evicted == nil {
+			// Nothing to evict; return claimed eviction, try again
+			atomic.AddInt64(&c.len, 1)
+		}
+		return evicted, true
+	}
+	popElements, blocked := c.popClaimed(1)
+	if len(popElements) == 0 {
+		// Pop failed; return claimed eviction, try again unless only pinned
+		// entries are left
+		atomic.AddInt64(&c.len, 1)
+		return nil, !blocked
+	}
+	return c.evicted(popElements[0]), true
+}
+
+// popClaimed pops n claimed evictions like popOldestN. As long as insertions
+// are pending, it waits for them to arrive rather than come up short: a
+// concurrent Add could take the place of a returned claim in the meantime,
+// and so go beyond the bound of WithMaxOvershoot.
+func (c *LRU) popClaimed(n int) ([]*element, bool) {
+	var popElements []*element
+	for {
+		// Check before popping, so an insertion that completes in between
+		// is still found
+		pending := atomic.LoadInt64(&c.evict.nPendingInsertions)
+		more, blocked := c.popOldestN(n - len(popElements))
+		popElements = append(popElements, more...)
+		if len(popElements) == n || blocked || pending == 0 {
+			return popElements, blocked
+		}
+		runtime.Gosched()
+	}
+}
+
+// evictExcess evicts a batch of the least recently used entries if the cache
+// holds more than limit entries. It returns whether the cache was over the
+// limit, in which case the caller may want to try again.
+func (c *LRU) evictExcess(limit int) bool {
+	// Count the evictions as in progress before claiming them, see Flush
+	atomic.AddInt64(&c.evicting, 1)
+	defer c.evictionDone()
+
+	n := c.Len()
+	if n <= limit {
+		return false
+	}
+
+	// Claim the evictions by decrementing the counter
+	batch := c.evictBatchSize(n - limit)
+	if !atomic.CompareAndSwapInt64(&c.len, int64(n), int64(n-batch)) {
+		return true // Claim failed, try again
+	}
+
+	if c.policy != nil {
+		for i := 0; i < batch; i++ {
+			if c.evictVictim() == nil {
+				// Return the remaining claimed evictions, try again
+				atomic.AddInt64(&c.len, int64(batch-i))
+				break
+			}
+		}
+		return true
+	}
+	popElements, blocked := c.popClaimed(batch)
+	if missing := batch - len(popElements); missing > 0 {
+		// Pop came up short; return claimed evictions, try again unless only
+		// pinned entries are left
+		atomic.AddInt64(&c.len, int64(missing))
+	}
+	for _, popElement := range popElements {
+		c.evicted(popElement)
+	}
+	return !blocked
+}
+
+// evictVictim evicts the entry chosen by c.policy, if any, and returns it.
+func (c *LRU) evictVictim() *item {
+	c.policyMutex.Lock()
+	evicted := c.popVictim()
+	c.policyMutex.Unlock()
+
+	if evicted != nil {
+		atomic.AddInt64(&c.stats.evictions, 1)
+		c.spillEvicted(evicted)
+		c.callOnEvict(evicted)
+	}
+	return evicted
+}
+
+// popVictim removes the entry chosen by c.policy, if any, from the policy and
+// the map, and returns it. The caller must hold c.policyMutex, and count and
+// report the eviction.
+func (c *LRU) popVictim() *item {
+	key, ok := c.policy.Victim()
+	if !ok {
+		return nil
+	}
+	c.policy.Remove(key)
+	v, _ := c.shard(key).Pop(key)
+	return v.(*item)
+}
+
+// evictBatchSize returns the number of entries to evict at once if the cache
+// holds overshoot entries too many. Unless fixed through evictBatch, this is
+// the overshoot, so that the cleanup worker catches up quickly from a burst
+// while evicting a single entry at a time under light load, bounded to
+// maxEvictBatch.
+func (c *LRU) evictBatchSize(overshoot int) int {
+	batch := c.evictBatch
+	if batch <= 0 {
+		batch = maxEvictBatch
+	}
+	if overshoot < batch {
+		return overshoot
+	}
+	return batch
+}
+
+// evicted removes the entry of popElement, which was popped from the evict
+// list, from the map and reports the eviction. It returns the evicted item.
+func (c *LRU) evicted(popElement *element) *item {
+	atomic.AddInt64(&c.stats.evictions, 1)
+	popItem := popElement.Value.(*item)
+	c.shard(popItem.key).RemoveCb(popItem.key,
+		func(key string, v interface{}, exists bool) bool {
+			// Check that the map entry was not replaced in the meantime
+			if !exists {
+				return false
+			}
+			if mapItem := v.(*item); mapItem.evictElement == popElement {
+				popItem = mapItem // Report the latest value for this entry
+				return true
+			}
+			return false
+		})
+	c.spillEvicted(popItem)
+	c.callOnEvict(popItem)
+	popElement.Value = nil
+	return popItem
+}
+
+// callOnEvict calls the eviction callback, if any, for an evicted item, or
+// queues the call for the eviction pool if there is one.
+func (c *LRU) callOnEvict(evicted *item) {
+	if c.onEvict == nil {
+		return
+	}
+	if c.evictQueue != nil {
+		// In progress for Flush until an evictWorker is done with it
+		atomic.AddInt64(&c.evicting, 1)
+		c.evictQueue <- evicted
+		return
+	}
+	c.runOnEvict(evicted)
+}
+
+// evictWorker calls the eviction callback for queued items, see WithEvictPool.
+func (c *LRU) evictWorker() {
+	defer c.evictPool.Done()
+	for evicted := range c.evictQueue {
+		c.runOnEvict(evicted)
+		c.evictionDone()
+	}
+}
+
+// runOnEvict calls the eviction callback for an evicted item.
+// It recovers from panics in the callback, so they can't stop evictions.
+func (c *LRU) runOnEvict(evicted *item) {
+	defer func() {
+		if r := recover(); r != nil && c.onEvictPanic != nil {
+			c.onEvictPanic(evicted.key, r)
+		}
+	}()
+	c.onEvict(evicted.key, evicted.value)
+}
+
+// evictDownTo synchronously evicts entries until the cache holds at most
+// limit entries, or only pinned entries are left to evict. Evictions by the
+// cleanup worker count towards the goal.
+func (c *LRU) evictDownTo(limit int) {
+	for c.Len() > limit {
+		evicted, again := c.evictOldest(limit)
+		if evicted == nil {
+			if !again {
+				return
+			}
+			// Lost a race, or the oldest entries are still being inserted
+			runtime.Gosched()
+		}
+	}
+}
+
+// Entry is a key-value pair of an LRU cache.
+type Entry struct {
+	Key   string
+	Value interface{}
+}
+
 // Add inserts a value to the cache, returns true if an eviction
 // occurred and updates the "recently used"-ness of the key.
 func (c *LRU) Add(key, value interface{}) bool {
@@ -126,41 +569,201 @@ func (c *LRU) Add(key, value interface{}) bool {
 		return false // TODO: Report error, but interface does not have it
 	}
 
-	v := c.items.Upsert(keyStr, value,
+	if inserted, evicted := c.upsert(keyStr, value); inserted {
+		over := c.inserted(1)
+		return over || evicted != nil
+	}
+	return false
+}
+
+// AddReturningEvicted is like Add, but if the insertion takes the cache over
+// capacity, it evicts the least recently used entry synchronously and returns
+// its key and value. Only if concurrent insertions keep the cleanup worker
+// busy, it may evict that entry first, in which case evicted is false.
+func (c *LRU) AddReturningEvicted(key string, value interface{}) (evictedKey string, evictedValue interface{}, evicted bool) {
+	inserted, victim := c.upsert(key, value)
+	if !inserted {
+		return "", nil, false
+	}
+	if victim != nil {
+		// An eviction policy made room already
+		c.inserted(1)
+		return victim.key, victim.value, true
+	}
+
+	// Make room before counting the new entry, so the cleanup worker
+	// doesn't see the cache over capacity and evict in our place
+	capacity := c.Cap()
+	for capacity > 0 && c.Len() >= capacity { // Capacity is 0 once closed
+		victim, again := c.evictOldest(capacity - 1)
+		if victim != nil {
+			evictedKey, evictedValue, evicted = victim.key, victim.value, true
+			break
+		}
+		if !again {
+			break // Only pinned entries are left, exceed the capacity
+		}
+		// Lost a race, or the oldest entries are still being inserted
+		runtime.Gosched()
+	}
+	c.inserted(1)
+	return evictedKey, evictedValue, evicted
+}
+
+// Warmup adds entries to the cache oldest first, so that the recency of the
+// entries reflects their order in the slice. It is equivalent to calling Add
+// for each entry, but only signals the cleanup worker once.
+// It returns true if an eviction occurred.
+func (c *LRU) Warmup(entries []Entry) bool {
+	n := 0
+	evictedAny := false
+	for _, entry := range entries {
+		inserted, evicted := c.upsert(entry.Key, entry.Value)
+		if inserted {
+			n++
+		}
+		evictedAny = evictedAny || evicted != nil
+	}
+	if n > 0 {
+		over := c.inserted(n)
+		return over || evictedAny
+	}
+	return evictedAny
+}
+
+// Merge adds the entries of other to the cache, oldest first, so that they
+// keep their relative recency and are the most recently used entries
+// afterwards. For a key that is in both caches, the merged value is the
+// result of onConflict applied to the existing and the incoming value, or
+// the incoming value if onConflict is nil. The merge takes the cache over
+// capacity like Add, evicting its least recently used entries as needed.
+// other is read from snapshots, like GetOldestN and SnapshotMap, and the
+// merge is not atomic with respect to concurrent updates of the same keys.
+// For an other cache created by NewWithPolicy, the entries are merged in no
+// particular order. onConflict must not access the cache.
+func (c *LRU) Merge(other *LRU, onConflict func(key string, existing, incoming interface{}) interface{}) {
+	entries := other.GetOldestN(other.Len())
+	merged := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		merged[entry.Key] = true
+	}
+	// Entries whose insertion or move to front is pending are the newest
+	for key, value := range other.SnapshotMap() {
+		if !merged[key] {
+			entries = append(entries, Entry{Key: key, Value: value})
+		}
+	}
+
+	if onConflict != nil {
+		for i, entry := range entries {
+			if existing, ok := c.peek(entry.Key); ok {
+				entries[i].Value = onConflict(entry.Key, existing, entry.Value)
+			}
+		}
+	}
+	c.Warmup(entries)
+}
+
+// upsert stores value under key and updates its "recently used"-ness.
+// It returns whether a new entry was inserted, which the caller must count
+// through inserted, and reports such insertions to onInsert.
+// With an eviction policy, it first evicts an entry to make room for a new
+// one if the cache is full, so that the policy can't choose the new entry.
+// It then also returns the evicted item.
+func (c *LRU) upsert(key string, value interface{}) (bool, *item) {
+	if c.closed() {
+		return false, nil // Nothing would evict the entry anymore
+	}
+	if c.policy != nil {
+		c.policyMutex.Lock()
+	}
+	inserted := false
+	c.shard(key).Upsert(key, value,
 		func(exist bool, valueInMap, newValue interface{}) interface{} {
 			if exist {
-				// TODO: I think it would be better if the items were immutable
-				// Update existing node
-				v := valueInMap.(item)
+				// Items are immutable once stored, so update a copy
+				v := *valueInMap.(*item)
 				// If the move to front fails, the item is being evicted,
 				// so insert a new item instead.
-				if c.evict.MoveToFront(v.evictElement) {
+				if c.policy != nil || c.evict.MoveToFront(v.evictElement) {
 					v.value = newValue
 					return &v
 				}
 			}
 
-			// Create new node
-			v := item{
-				key:          keyStr,
-				value:        newValue,
-				evictElement: nil,
+			// Create new node and add it to the evict list
+			v := &item{
+				key:    key,
+				value:  newValue,
+				access: c.newAccessStats(),
+			}
+			if c.policy == nil {
+				v.evictElement = c.evict.PushFront(v)
+			}
+			inserted = true
+			return v
+		})
+	var evicted *item
+	if c.policy != nil {
+		if capacity := c.Cap(); inserted && capacity > 0 && c.Len() >= capacity {
+			atomic.AddInt64(&c.evicting, 1)
+			if evicted = c.popVictim(); evicted != nil {
+				atomic.AddInt64(&c.len, -1)
+			} else {
+				c.evictionDone()
 			}
-			return &v
-		}).(*item)
-	if v.evictElement == nil {
-		// new element inserted, count it and add to evict list
-		c.cleanup.L.Lock()
-		n := int(atomic.AddInt64(&c.len, 1))
-		c.cleanup.L.Unlock()
-		v.evictElement = c.evict.PushFront(v)
-		if n > c.capacity {
-			// actual cleanup happens in the background
-			c.cleanup.Signal()
-			return true
 		}
+		c.policy.Record(key)
+		c.policyMutex.Unlock()
 	}
+	if evicted != nil {
+		atomic.AddInt64(&c.stats.evictions, 1)
+		c.spillEvicted(evicted)
+		c.callOnEvict(evicted)
+		c.evictionDone()
+	}
+	if inserted && c.onInsert != nil {
+		c.onInsert(key, value)
+	}
+	return inserted, evicted
+}
 
+// inserted counts n newly inserted entries and triggers their cleanup if
+// that takes the cache over capacity, which it reports as an eviction.
+func (c *LRU) inserted(n int) bool {
+	capacity := c.Cap()
+	madeRoom := false
+	c.cleanup.L.Lock()
+	if c.maxOvershoot >= 0 && !c.syncEvict {
+		// The cleanup worker may be falling behind. Make room before counting
+		// the entries, so that concurrent Adds can't overshoot together.
+		limit := capacity + c.maxOvershoot - n
+		if limit < 0 {
+			limit = 0
+		}
+		for c.Len() > limit && !c.blockedByPins() {
+			madeRoom = true
+			c.cleanup.L.Unlock()
+			c.evictDownTo(limit)
+			c.cleanup.L.Lock()
+		}
+	}
+	newLen := int(atomic.AddInt64(&c.len, int64(n)))
+	c.evictionsUnblocked()
+	c.cleanup.L.Unlock()
+	if newLen > capacity || madeRoom {
+		if c.syncEvict {
+			c.TrimToSize()
+			return true
+		}
+		if c.maxOvershoot >= 0 && newLen > capacity+c.maxOvershoot {
+			// Only pinned entries were left, or n alone exceeds the bound
+			c.evictDownTo(capacity + c.maxOvershoot)
+		}
+		// actual cleanup happens in the background
+		c.cleanup.Signal()
+		return true
+	}
 	return false
 }
 
@@ -169,22 +772,176 @@ func (c *LRU) Add(key, value interface{}) bool {
 func (c *LRU) Get(key interface{}) (value interface{}, ok bool) {
 	keyStr, ok := key.(string)
 	if ok {
-		mapEntry, ok := c.items.Get(keyStr)
-		if ok {
-			mapItem, ok := mapEntry.(*item)
-			if ok && c.evict.MoveToFront(mapItem.evictElement) {
-				return mapItem.value, ok
-			}
+		if c.hotKeys != nil {
+			c.hotKeys.record(keyStr)
+		}
+		if mapItem, ok := c.use(keyStr); ok {
+			atomic.AddInt64(&c.stats.hits, 1)
+			return mapItem.value, ok
 		}
 	}
+	atomic.AddInt64(&c.stats.misses, 1)
 	return nil, false
 }
 
+// TryGet is like Get, but never waits for the evict list: it returns key's
+// value and whether it was found, and whether the update of its "recently
+// used"-ness was scheduled. If that would block, e.g. because Get calls
+// contend on the evict list, the key is only peeked at, and bumped is false.
+// A cache created by NewWithPolicy serialises the uses of its policy, so
+// TryGet never updates recency there.
+func (c *LRU) TryGet(key interface{}) (value interface{}, ok, bumped bool) {
+	keyStr, ok := key.(string)
+	if ok {
+		if c.hotKeys != nil {
+			c.hotKeys.record(keyStr)
+		}
+		var mapItem *item
+		if mapItem, ok = c.peekItem(keyStr); ok && !c.closed() {
+			atomic.AddInt64(&c.stats.hits, 1)
+			c.recordAccess(mapItem)
+			bumped = c.policy == nil && c.evict.TryMoveToFront(mapItem.evictElement)
+			return mapItem.value, true, bumped
+		}
+	}
+	atomic.AddInt64(&c.stats.misses, 1)
+	return nil, false, false
+}
+
+// use looks up key and updates its "recently used"-ness,
+// skipping entries that are being evicted.
+func (c *LRU) use(key string) (*item, bool) {
+	if c.closed() {
+		return nil, false
+	}
+	if c.policy != nil {
+		// Record the use only while the entry is still in the map
+		c.policyMutex.Lock()
+		defer c.policyMutex.Unlock()
+	}
+	mapEntry, ok := c.shard(key).Get(key)
+	if !ok {
+		return nil, false
+	}
+	mapItem := mapEntry.(*item)
+	if c.policy != nil {
+		c.policy.Record(key)
+	} else if !c.evict.MoveToFront(mapItem.evictElement) {
+		return mapItem, false
+	}
+	c.recordAccess(mapItem)
+	return mapItem, true
+}
+
+// WithValue replaces key's value by the result of fn applied to it, and
+// reports whether key was found. The read-modify-write is atomic, so
+// concurrent calls for the same key are serialised, which makes it safe to
+// update mutable values through fn. It does not update the "recently
+// used"-ness of the key. fn must not access the cache.
+func (c *LRU) WithValue(key string, fn func(value interface{}) interface{}) bool {
+	if c.policy != nil {
+		// Entries are only added and removed while holding policyMutex
+		c.policyMutex.Lock()
+		defer c.policyMutex.Unlock()
+		if !c.shard(key).Has(key) {
+			return false
+		}
+	}
+
+	found := false
+	var placeholder *item
+	c.shard(key).Upsert(key, nil,
+		func(exist bool, valueInMap, _ interface{}) interface{} {
+			if !exist {
+				// Upsert always stores a value, so store one that lookups
+				// skip like an entry being evicted, and remove it below
+				placeholder = &item{key: key, evictElement: &element{}}
+				return placeholder
+			}
+			mapItem := valueInMap.(*item)
+			if c.policy == nil && !c.evict.Contains(mapItem.evictElement) {
+				return mapItem // popped from the evict list, removal is pending
+			}
+			// Items are immutable once stored, so update a copy
+			v := *mapItem
+			v.value = fn(v.value)
+			found = true
+			return &v
+		})
+	if placeholder != nil {
+		c.shard(key).RemoveCb(key, func(_ string, v interface{}, exists bool) bool {
+			return exists && v.(*item) == placeholder
+		})
+	}
+	return found
+}
+
+// CompareAndSwap replaces key's value by new if it is equal to old according
+// to eq, and reports whether it did. Like WithValue, the comparison and swap
+// are atomic, and it does not update the "recently used"-ness of the key.
+// eq must not access the cache.
+func (c *LRU) CompareAndSwap(key string, old, new interface{}, eq func(a, b interface{}) bool) bool {
+	swapped := false
+	c.WithValue(key, func(value interface{}) interface{} {
+		if !eq(value, old) {
+			return value
+		}
+		swapped = true
+		return new
+	})
+	return swapped
+}
+
+// Remove removes key from the cache and reports whether it was found. As in
+// simplelru, the eviction callback is called for the removed entry, but it
+// isn't counted as an eviction. An entry that is being evicted concurrently
+// is not found.
+func (c *LRU) Remove(key interface{}) bool {
+	keyStr, ok := key.(string)
+	if !ok || c.closed() {
+		return false
+	}
+	if c.policy != nil {
+		// Entries are only added and removed while holding policyMutex
+		c.policyMutex.Lock()
+	}
+	var removed *item
+	c.shard(keyStr).RemoveCb(keyStr, func(_ string, v interface{}, exists bool) bool {
+		if !exists {
+			return false
+		}
+		// Claim the removal before the entry is gone, so that evictions
+		// never count it
+		atomic.AddInt64(&c.len, -1)
+		mapItem := v.(*item)
+		if c.policy == nil && !c.evict.Remove(mapItem.evictElement) {
+			// Popped from the evict list, or a placeholder of WithValue
+			atomic.AddInt64(&c.len, 1)
+			return false
+		}
+		removed = mapItem
+		return true
+	})
+	if c.policy != nil {
+		if removed != nil {
+			c.policy.Remove(keyStr)
+		}
+		c.policyMutex.Unlock()
+	}
+	if removed == nil {
+		return false
+	}
+	atomic.AddInt64(&c.evicting, 1)
+	c.callOnEvict(removed)
+	c.evictionDone()
+	return true
+}
+
 // Contains checks if a key exists in cache without updating the recent-ness.
 func (c *LRU) Contains(key interface{}) (ok bool) {
 	keyStr, ok := key.(string)
 	if ok {
-		_, ok := c.items.Get(keyStr)
+		_, ok := c.peek(keyStr)
 		return ok
 	}
 	return false
@@ -194,16 +951,70 @@ func (c *LRU) Contains(key interface{}) (ok bool) {
 func (c *LRU) Peek(key interface{}) (value interface{}, ok bool) {
 	keyStr, ok := key.(string)
 	if ok {
-		mapEntry, ok := c.items.Get(keyStr)
-		if ok {
-			return mapEntry.(*item).value, true
+		return c.peek(keyStr)
+	}
+	return nil, false
+}
+
+// PeekMulti returns the values of all keys that are in the cache, without
+// updating the "recently used"-ness of any of them. Keys that are missing or
+// being evicted are not included in the result.
+func (c *LRU) PeekMulti(keys []string) map[string]interface{} {
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if value, ok := c.peek(key); ok {
+			values[key] = value
 		}
 	}
+	return values
+}
+
+// ContainsAll reports whether all keys are in the cache, without updating
+// the "recently used"-ness of any of them. It stops at the first key that is
+// missing or being evicted. It returns true if keys is empty.
+func (c *LRU) ContainsAll(keys []string) bool {
+	for _, key := range keys {
+		if _, ok := c.peekItem(key); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// GetAll returns the values of all keys that are in the cache, like Get for
+// each of them, and updates the "recently used"-ness of those keys. Keys
+// that are missing or being evicted are not included in the result.
+func (c *LRU) GetAll(keys []string) map[string]interface{} {
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if value, ok := c.Get(key); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// peek looks up key without updating its "recently used"-ness,
+// skipping entries that are being evicted.
+func (c *LRU) peek(key string) (interface{}, bool) {
+	if mapItem, ok := c.peekItem(key); ok {
+		return mapItem.value, true
+	}
 	return nil, false
 }
 
-// // Removes a key from the cache.
-// Remove(key interface{}) bool
+// peekItem is like peek, but returns the item holding key's value.
+func (c *LRU) peekItem(key string) (*item, bool) {
+	mapEntry, ok := c.shard(key).Get(key)
+	if !ok {
+		return nil, false
+	}
+	mapItem := mapEntry.(*item)
+	if c.policy == nil && !c.evict.Contains(mapItem.evictElement) {
+		return nil, false // popped from the evict list, removal is pending
+	}
+	return mapItem, true
+}
 
 // // Removes the oldest entry from cache.
 // RemoveOldest() (interface{}, interface{}, bool)
@@ -211,14 +1022,306 @@ func (c *LRU) Peek(key interface{}) (value interface{}, ok bool) {
 // // Returns the oldest entry from the cache. #key, value, isFound
 // GetOldest() (interface{}, interface{}, bool)
 
-// // Returns a slice of the keys in the cache, from oldest to newest.
-// Keys() []interface{}
+// Keys returns a slice of the keys in the cache, from oldest to newest.
+// Entries whose insertion or move to front is still pending are not included.
+func (c *LRU) Keys() []interface{} {
+	keys := make([]interface{}, 0, c.Len())
+	for _, key := range c.newestKeys() {
+		keys = append(keys, key)
+	}
+	// The list is walked from newest to oldest
+	for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
+	}
+	return keys
+}
+
+// RangeAndTouch calls f for the entries in the cache, from oldest to newest,
+// until f returns false, and updates the "recently used"-ness of each entry
+// visited as Get does, so that a refresh pass keeps them in the cache.
+// It visits the entries of a snapshot of the keys, like Keys, so touching
+// them can't make the walk loop or skip any; afterwards, the visited entries
+// are the most recently used ones, in their previous order. Entries that are
+// evicted before they are visited are skipped, as are those whose insertion
+// or move to front is pending when the snapshot is taken. For a cache
+// created by NewWithPolicy, the snapshot is in no particular order.
+// f is called without holding any locks, so it may access the cache.
+func (c *LRU) RangeAndTouch(f func(key string, value interface{}) bool) {
+	var keys []string
+	if c.policy == nil {
+		keys = c.newestKeys()
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	} else {
+		keys = c.KeysMatching(func(string) bool { return true })
+	}
+
+	for _, key := range keys {
+		if mapItem, ok := c.use(key); ok && !f(key, mapItem.value) {
+			return
+		}
+	}
+}
+
+// KeysMatching returns the keys in the cache for which pred returns true, in
+// no particular order, without updating the "recently used"-ness of any key.
+// Unlike Keys, it includes entries whose insertion is still pending, and it
+// works for caches created by NewWithPolicy too. Each shard of the cache is
+// read from a consistent snapshot, but not all of them at the same time.
+// pred must not access the cache.
+func (c *LRU) KeysMatching(pred func(key string) bool) []string {
+	var keys []string
+	c.eachItem(func(mapItem *item) {
+		if pred(mapItem.key) {
+			keys = append(keys, mapItem.key)
+		}
+	})
+	return keys
+}
+
+// SnapshotMap returns a copy of the entries in the cache as a map from their
+// keys to their values, without updating the "recently used"-ness of any key.
+// Like KeysMatching, it includes entries whose insertion is still pending.
+// It is not a point-in-time copy of the whole cache: each shard is copied
+// consistently, but the shards one after another, so concurrent updates may
+// show in some shards and not in others. Stop updating the cache first for a
+// fully consistent copy.
+func (c *LRU) SnapshotMap() map[string]interface{} {
+	values := make(map[string]interface{}, c.Len())
+	c.eachItem(func(mapItem *item) {
+		values[mapItem.key] = mapItem.value
+	})
+	return values
+}
+
+// eachItem calls f for the item of each entry in the cache, shard by shard,
+// skipping entries that are being evicted. f is called while holding a lock
+// of the shard, so it must not access the cache.
+func (c *LRU) eachItem(f func(mapItem *item)) {
+	for _, shard := range c.items {
+		shard.IterCb(func(_ string, v interface{}) {
+			mapItem := v.(*item)
+			if c.policy == nil && !c.evict.Contains(mapItem.evictElement) {
+				return // popped from the evict list, removal is pending
+			}
+			f(mapItem)
+		})
+	}
+}
+
+// GetOldestN returns up to n of the least recently used entries, from oldest
+// to newest, without updating the "recently used"-ness of any key. Entries
+// being evicted, and those whose insertion is still pending, are skipped.
+// Like Keys, it reports no entries for a cache created by NewWithPolicy.
+func (c *LRU) GetOldestN(n int) []Entry {
+	if n <= 0 {
+		return nil
+	}
+	var elements []*element
+	var keys []string
+	c.evict.eachBack(func(e *element) bool {
+		elements = append(elements, e)
+		keys = append(keys, e.Value.(*item).key)
+		return len(elements) < n
+	})
+
+	// Look up the values afterwards: updates store them in a new item
+	entries := make([]Entry, 0, len(elements))
+	for i, e := range elements {
+		if mapItem, ok := c.peekItem(keys[i]); ok && mapItem.evictElement == e {
+			entries = append(entries, Entry{Key: keys[i], Value: mapItem.value})
+		}
+	}
+	return entries
+}
+
+// DumpOrder returns the keys in the cache from newest to oldest, so the index
+// of a key is its recency: 0 for the most recently used one. Meant for
+// debugging, it first waits for pending insertions and moves to front to
+// settle, which may take a while under heavy load.
+func (c *LRU) DumpOrder() []string {
+	for atomic.LoadInt64(&c.evict.nPendingInsertions) > 0 {
+		runtime.Gosched()
+	}
+	return c.newestKeys()
+}
+
+// String renders the keys of the cache with their recency as in DumpOrder,
+// e.g. "[c(0) a(1) b(2)]".
+func (c *LRU) String() string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, key := range c.DumpOrder() {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s(%d)", key, i)
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// newestKeys returns the keys in the evict list, from newest to oldest.
+func (c *LRU) newestKeys() []string {
+	keys := make([]string, 0, c.Len())
+	c.evict.each(func(e *element) {
+		keys = append(keys, e.Value.(*item).key)
+	})
+	return keys
+}
+
+// checkInvariants verifies that the number of entries in the map, in the
+// evict list and in len agree, and that the evict list holds the element of
+// each entry. Meant for tests and debugging, it first waits for evictions and
+// pending insertions to settle, so the cache must not be used concurrently.
+func (c *LRU) checkInvariants() error {
+	c.Flush()
+	for atomic.LoadInt64(&c.evict.nPendingInsertions) > 0 {
+		runtime.Gosched()
+	}
+
+	var err error
+	count := 0
+	for _, shard := range c.items {
+		shard.IterCb(func(key string, v interface{}) {
+			count++
+			mapItem := v.(*item)
+			if err != nil || c.policy != nil {
+				return
+			}
+			if !c.evict.Contains(mapItem.evictElement) {
+				err = fmt.Errorf("entry %q is not in the evict list", key)
+			} else if listed := mapItem.evictElement.Value.(*item).key; listed != key {
+				err = fmt.Errorf("entry %q has the element of entry %q", key, listed)
+			}
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if n := c.Len(); count != n {
+		return fmt.Errorf("map holds %d entries, but len is %d", count, n)
+	}
+	if c.policy != nil {
+		return nil // The evict list is not used
+	}
+	listed := 0
+	c.evict.each(func(*element) { listed++ })
+	if n := c.evict.Len(); listed != n || listed != count {
+		return fmt.Errorf("evict list holds %d elements, but its len is %d and the map holds %d entries",
+			listed, n, count)
+	}
+	return nil
+}
 
 // Len returns the number of items in the cache.
 func (c *LRU) Len() int {
 	return int(atomic.LoadInt64(&c.len))
 }
 
+// Cap returns the capacity of the cache.
+func (c *LRU) Cap() int {
+	return int(atomic.LoadInt64(&c.capacity))
+}
+
+// Load returns how full the cache is, as the fraction of its capacity that
+// is in use, clamped to [0, 1] since the cache may temporarily exceed its
+// capacity. A closed cache has no capacity left, so it reports 1.
+func (c *LRU) Load() float64 {
+	n, capacity := c.lenAndCap()
+	if n >= capacity {
+		return 1
+	}
+	return float64(n) / float64(capacity)
+}
+
+// IsFull reports whether the cache holds at least as many entries as its
+// capacity, so that the next insertion of a new key causes an eviction.
+func (c *LRU) IsFull() bool {
+	n, capacity := c.lenAndCap()
+	return n >= capacity
+}
+
+// lenAndCap returns the length and capacity of the cache consistently,
+// holding off insertions and capacity changes while reading them.
+func (c *LRU) lenAndCap() (int, int) {
+	c.cleanup.L.Lock()
+	defer c.cleanup.L.Unlock()
+	return c.Len(), c.Cap()
+}
+
+// Collect returns a snapshot of the cache's metrics. The counters are read
+// individually without locking, so they may be slightly out of sync.
+func (c *LRU) Collect() Metrics {
+	return Metrics{
+		Len:               c.Len(),
+		Cap:               c.Cap(),
+		Hits:              atomic.LoadInt64(&c.stats.hits),
+		Misses:            atomic.LoadInt64(&c.stats.misses),
+		Evictions:         atomic.LoadInt64(&c.stats.evictions),
+		PendingInsertions: atomic.LoadInt64(&c.evict.nPendingInsertions),
+		SpillErrors:       atomic.LoadInt64(&c.stats.spillErrors),
+	}
+}
+
+// Flush blocks until the cache is back within its capacity, or only pinned
+// entries are left to evict, and all evictions in progress, including their
+// eviction callbacks, have completed.
+// Evictions caused by concurrent insertions may still be in progress when
+// it returns.
+func (c *LRU) Flush() {
+	c.flushed.L.Lock()
+	defer c.flushed.L.Unlock()
+	for (c.Len() > c.Cap() && !c.blockedByPins()) || atomic.LoadInt64(&c.evicting) > 0 {
+		// The cleanup worker is signalled whenever the cache exceeds capacity,
+		// and each of its evictions ends in evictionDone
+		c.flushed.Wait()
+	}
+}
+
+// evictionDone marks an eviction counted in evicting as completed, and wakes
+// up Flush once none are left in progress.
+func (c *LRU) evictionDone() {
+	if atomic.AddInt64(&c.evicting, -1) == 0 {
+		c.flushed.L.Lock()
+		c.flushed.Broadcast()
+		c.flushed.L.Unlock()
+	}
+}
+
+// TrimToSize evicts the least recently used entries until the cache is
+// within its capacity, or only pinned entries are left, without waiting for
+// the cleanup worker. It calls the eviction callback inline for the entries
+// it evicts itself, and blocks until the callbacks of evictions already in
+// progress have completed as well.
+// Like Flush, it does not hold off concurrent insertions.
+func (c *LRU) TrimToSize() {
+	if c.closed() {
+		return
+	}
+	c.evictDownTo(c.Cap())
+	c.Flush()
+}
+
+// SetCapacity changes the capacity of the cache to n, which must be positive.
+// When downsizing, the excess entries are evicted in the background.
+func (c *LRU) SetCapacity(n int) error {
+	if n <= 0 {
+		return errors.New("must provide a positive size")
+	}
+
+	c.cleanup.L.Lock()
+	defer c.cleanup.L.Unlock()
+	if c.closed() {
+		return ErrClosed
+	}
+	atomic.StoreInt64(&c.capacity, int64(n))
+	c.evictionsUnblocked()
+	c.cleanup.Signal()
+	return nil
+}
+
 // // Clears all cache entries.
 // Purge()
 
@@ -10,72 +10,198 @@
 package lru
 
 import (
+	clist "container/list"
+	"fmt"
+	"math/rand"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
-// func BenchmarkLRU_Rand(b *testing.B) {
-// 	l, err := New(8192)
-// 	if err != nil {
-// 		b.Errorf("err: %v", err)
-// 	}
-
-// 	trace := make([]int64, b.N*2)
-// 	for i := 0; i < b.N*2; i++ {
-// 		trace[i] = rand.Int63() % 32768
-// 	}
-
-// 	b.ResetTimer()
-
-// 	var hit, miss int
-// 	for i := 0; i < 2*b.N; i++ {
-// 		if i%2 == 0 {
-// 			l.Add(trace[i], trace[i])
-// 		} else {
-// 			_, ok := l.Get(trace[i])
-// 			if ok {
-// 				hit++
-// 			} else {
-// 				miss++
-// 			}
-// 		}
-// 	}
-// 	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(miss))
-// }
-
-// func BenchmarkLRU_Freq(b *testing.B) {
-// 	l, err := New(8192)
-// 	if err != nil {
-// 		b.Errorf("err: %v", err)
-// 	}
-
-// 	trace := make([]int64, b.N*2)
-// 	for i := 0; i < b.N*2; i++ {
-// 		if i%2 == 0 {
-// 			trace[i] = rand.Int63() % 16384
-// 		} else {
-// 			trace[i] = rand.Int63() % 32768
-// 		}
-// 	}
-
-// 	b.ResetTimer()
-
-// 	for i := 0; i < b.N; i++ {
-// 		l.Add(trace[i], trace[i])
-// 	}
-// 	var hit, miss int
-// 	for i := 0; i < b.N; i++ {
-// 		_, ok := l.Get(trace[i])
-// 		if ok {
-// 			hit++
-// 		} else {
-// 			miss++
-// 		}
-// 	}
-// 	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(miss))
-// }
+// Keys in the traces are strings, the only key type the cache supports
+func benchmarkTrace(n int, keyRange func(i int) int64) []string {
+	trace := make([]string, n)
+	for i := 0; i < n; i++ {
+		trace[i] = strconv.FormatInt(rand.Int63()%keyRange(i), 10)
+	}
+	return trace
+}
+
+// Count the entries in the map of c, including those pending eviction
+func (c *LRU) itemCount() int {
+	n := 0
+	for _, m := range c.items {
+		n += m.Count()
+	}
+	return n
+}
+
+// closeChecked closes l after checking its invariants. Tests defer it in
+// place of Close.
+func closeChecked(t *testing.T, l *LRU) {
+	t.Helper()
+	if err := l.checkInvariants(); err != nil {
+		t.Errorf("invariants violated: %v", err)
+	}
+	l.Close()
+}
+
+func BenchmarkLRU_Rand(b *testing.B) {
+	l, err := New(8192)
+	if err != nil {
+		b.Errorf("err: %v", err)
+	}
+	defer l.Close()
+
+	trace := benchmarkTrace(b.N*2, func(int) int64 { return 32768 })
+
+	b.ResetTimer()
+
+	var hit, miss int
+	for i := 0; i < 2*b.N; i++ {
+		if i%2 == 0 {
+			l.Add(trace[i], trace[i])
+		} else {
+			_, ok := l.Get(trace[i])
+			if ok {
+				hit++
+			} else {
+				miss++
+			}
+		}
+	}
+	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(miss))
+}
+
+func BenchmarkLRU_Freq(b *testing.B) {
+	l, err := New(8192)
+	if err != nil {
+		b.Errorf("err: %v", err)
+	}
+	defer l.Close()
+
+	trace := benchmarkTrace(b.N*2, func(i int) int64 {
+		if i%2 == 0 {
+			return 16384
+		}
+		return 32768
+	})
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		l.Add(trace[i], trace[i])
+	}
+	var hit, miss int
+	for i := 0; i < b.N; i++ {
+		_, ok := l.Get(trace[i])
+		if ok {
+			hit++
+		} else {
+			miss++
+		}
+	}
+	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(miss))
+}
+
+// Add bursts of new keys, to compare eviction batch sizes
+func benchmarkLRUBursty(b *testing.B, evictBatch int) {
+	l, err := New(8192)
+	if err != nil {
+		b.Errorf("err: %v", err)
+	}
+	defer l.Close()
+	l.evictBatch = evictBatch
+
+	trace := benchmarkTrace(1<<16, func(int) int64 { return 1 << 20 })
+	const burst = 1024
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		l.Add(trace[i%len(trace)], i)
+		if i%burst == burst-1 {
+			// Pause between bursts, giving the cleanup worker time to catch up
+			for l.Len() > l.Cap() {
+				runtime.Gosched()
+			}
+		}
+	}
+}
+
+func BenchmarkLRU_BurstyAdaptive(b *testing.B) { benchmarkLRUBursty(b, 0) }
+func BenchmarkLRU_BurstyFixed(b *testing.B)    { benchmarkLRUBursty(b, 1) }
+
+func BenchmarkLRU_Parallel(b *testing.B) {
+	l, err := New(8192)
+	if err != nil {
+		b.Errorf("err: %v", err)
+	}
+	defer l.Close()
+
+	trace := benchmarkTrace(1<<16, func(int) int64 { return 32768 })
+	var hit, miss int64
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		var h, m int64
+		for i := rand.Intn(len(trace)); pb.Next(); i = (i + 1) % len(trace) {
+			if i%2 == 0 {
+				l.Add(trace[i], trace[i])
+			} else if _, ok := l.Get(trace[i]); ok {
+				h++
+			} else {
+				m++
+			}
+		}
+		atomic.AddInt64(&hit, h)
+		atomic.AddInt64(&miss, m)
+	})
+	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(miss))
+}
+
+func BenchmarkLRU_GetParallel(b *testing.B)    { benchmarkLRUGet(b, false) }
+func BenchmarkLRU_TryGetParallel(b *testing.B) { benchmarkLRUGet(b, true) }
+
+// benchmarkLRUGet looks up a small set of hot keys from all goroutines,
+// so that the recency updates contend on the front of the evict list.
+func benchmarkLRUGet(b *testing.B, try bool) {
+	l, err := New(1024)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	defer l.Close()
+	keys := make([]string, 64)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		l.Add(keys[i], i)
+	}
+
+	b.ResetTimer()
+	var bumps int64
+	b.RunParallel(func(pb *testing.PB) {
+		var n int64
+		for i := rand.Intn(len(keys)); pb.Next(); i = (i + 1) % len(keys) {
+			if try {
+				if _, _, bumped := l.TryGet(keys[i]); bumped {
+					n++
+				}
+			} else {
+				l.Get(keys[i])
+			}
+		}
+		atomic.AddInt64(&bumps, n)
+	})
+	if try {
+		b.Logf("bumped: %d of %d", bumps, b.N)
+	}
+}
 
 func TestLRU(t *testing.T) {
 	evictCounter := int64(0)
@@ -87,7 +213,7 @@ func TestLRU(t *testing.T) {
 	}
 
 	l, err := NewWithEvict(128, onEvicted)
-	defer l.Close()
+	defer closeChecked(t, l)
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
@@ -190,10 +316,198 @@ func TestLRUAdd(t *testing.T) {
 	}
 }
 
+// test that the cleanup worker evicts down to exactly the capacity
+func TestLRUEvictConverges(t *testing.T) {
+	l, err := New(100)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	for i := 0; i < 1000; i++ {
+		l.Add(strconv.Itoa(i), i)
+	}
+	for l.Len() > l.Cap() || l.itemCount() > l.Cap() {
+		// test times out if the cache never converges
+		runtime.Gosched()
+	}
+	l.evict.waitForInsertions()
+	if n, m := l.Len(), l.evict.Len(); n != 100 || m != 100 {
+		t.Errorf("cache converged to Len() = %d and %d in the evict list, want 100", n, m)
+	}
+	for i := 900; i < 1000; i++ {
+		if !l.Contains(strconv.Itoa(i)) {
+			t.Errorf("%d should not have been evicted", i)
+		}
+	}
+}
+
+// test that AddReturningEvicted reports the evicted entry
+func TestLRUAddReturningEvicted(t *testing.T) {
+	var evictedKeys []interface{}
+	onEvicted := func(k interface{}, v interface{}) {
+		evictedKeys = append(evictedKeys, k)
+	}
+
+	l, err := NewWithEvict(1, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	if _, _, evicted := l.AddReturningEvicted("1", 1); evicted {
+		t.Errorf("should not have an eviction")
+	}
+	k, v, evicted := l.AddReturningEvicted("2", 2)
+	if !evicted || k != "1" || v != 1 {
+		t.Errorf("AddReturningEvicted(2) = %v, %v, %v, want 1, 1, true", k, v, evicted)
+	}
+	if len(evictedKeys) != 1 || evictedKeys[0] != "1" {
+		t.Errorf("bad evicted keys: %v", evictedKeys)
+	}
+	if _, _, evicted := l.AddReturningEvicted("2", 3); evicted {
+		t.Errorf("updating an existing key should not evict")
+	}
+	if v, ok := l.Peek("2"); !ok || v != 3 {
+		t.Errorf("bad value for 2: %v, %v", v, ok)
+	}
+}
+
+// test that the insert callback only reports new entries
+func TestLRUInsertCallback(t *testing.T) {
+	var inserted []Entry
+	onInsert := func(key string, value interface{}) {
+		inserted = append(inserted, Entry{key, value})
+	}
+	l, err := New(2, WithInsertCallback(onInsert))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("1", 1)
+	l.Add("2", 2)
+	l.Add("1", 3) // update
+	l.Get("2")    // recency update
+	l.Warmup([]Entry{{"3", 4}, {"2", 5}})
+	l.AddReturningEvicted("4", 6)
+
+	want := []Entry{{"1", 1}, {"2", 2}, {"3", 4}, {"4", 6}}
+	if len(inserted) != len(want) {
+		t.Fatalf("insert callback got %v, want %v", inserted, want)
+	}
+	for i := range want {
+		if inserted[i] != want[i] {
+			t.Errorf("insert callback got %v, want %v", inserted, want)
+			break
+		}
+	}
+}
+
+// test that a custom key hash distributes the keys over the shards
+func TestLRUKeyHash(t *testing.T) {
+	var calls int64
+	hash := func(key interface{}) uint32 {
+		atomic.AddInt64(&calls, 1)
+		return 3 // Put every key in the same shard
+	}
+	l, err := New(8, WithKeyHash(hash))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	for i := 0; i < 4; i++ {
+		l.Add(strconv.Itoa(i), i)
+	}
+	if v, ok := l.Get("2"); !ok || v != 2 {
+		t.Errorf("bad value for 2: %v, %v", v, ok)
+	}
+	if atomic.LoadInt64(&calls) == 0 {
+		t.Errorf("hash not called")
+	}
+	for i, m := range l.items {
+		if n := m.Count(); (i == 3) != (n == 4) {
+			t.Errorf("shard %d holds %d keys", i, n)
+		}
+	}
+}
+
+func TestLRURemove(t *testing.T) {
+	var evicted []interface{}
+	onEvicted := func(k interface{}, v interface{}) {
+		evicted = append(evicted, k)
+	}
+	l, err := NewWithEvict(3, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("a", 1)
+	l.Add("b", 2) // Possibly still pending
+	if !l.Remove("b") {
+		t.Errorf("Remove(b) should find b")
+	}
+	if l.Remove("b") || l.Remove("x") || l.Remove(1) {
+		t.Errorf("Remove of a missing key should not find it")
+	}
+	if l.Contains("b") || l.Len() != 1 {
+		t.Errorf("b should be removed, len %v", l.Len())
+	}
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("eviction callbacks for %v, want b", evicted)
+	}
+
+	// The removed entry doesn't count towards the capacity
+	l.Add("c", 3)
+	l.Add("d", 4)
+	if l.Len() != 3 || !l.Contains("a") || !l.Contains("c") || !l.Contains("d") {
+		t.Errorf("a, c and d should be cached, len %v", l.Len())
+	}
+	if n := l.Collect().Evictions; n != 0 {
+		t.Errorf("%d evictions, want 0", n)
+	}
+}
+
+func TestLRURemoveConcurrent(t *testing.T) {
+	// Big enough for all keys, so that only removals take entries out
+	l, err := New(256)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa((g*1000 + i) % 128)
+				l.Add(key, i)
+				l.Get(key)
+				l.Remove(strconv.Itoa(i % 128))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	count := 0
+	for i := 0; i < 128; i++ {
+		if l.Contains(strconv.Itoa(i)) {
+			count++
+		}
+	}
+	if n := l.Len(); n != count || l.evict.Len() != count {
+		t.Errorf("len %d and evict list len %d, but %d entries found", n, l.evict.Len(), count)
+	}
+}
+
 // test that Contains doesn't update recent-ness
 func TestLRUContains(t *testing.T) {
 	l, err := New(2)
-	defer l.Close()
+	defer closeChecked(t, l)
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
@@ -205,7 +519,7 @@ func TestLRUContains(t *testing.T) {
 	}
 
 	l.Add("3", 3)
-	for l.items.Count() > 2 {
+	for l.itemCount() > 2 {
 		// Wait for eviction to be handled
 		runtime.Gosched()
 	}
@@ -217,7 +531,7 @@ func TestLRUContains(t *testing.T) {
 // test that ContainsOrAdd doesn't update recent-ness
 func TestLRUContainsOrAdd(t *testing.T) {
 	l, err := New(2)
-	defer l.Close()
+	defer closeChecked(t, l)
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
@@ -248,7 +562,7 @@ func TestLRUContainsOrAdd(t *testing.T) {
 // test that PeekOrAdd doesn't update recent-ness
 func TestLRUPeekOrAdd(t *testing.T) {
 	l, err := New(2)
-	defer l.Close()
+	defer closeChecked(t, l)
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
@@ -282,7 +596,7 @@ func TestLRUPeekOrAdd(t *testing.T) {
 // test that Peek doesn't update recent-ness
 func TestLRUPeek(t *testing.T) {
 	l, err := New(2)
-	defer l.Close()
+	defer closeChecked(t, l)
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
@@ -294,7 +608,7 @@ func TestLRUPeek(t *testing.T) {
 	}
 
 	l.Add("3", 3)
-	for l.items.Count() > 2 {
+	for l.itemCount() > 2 {
 		// Wait for eviction to be handled
 		runtime.Gosched()
 	}
@@ -310,7 +624,7 @@ func TestLRUResize(t *testing.T) {
 		onEvictCounter++
 	}
 	l, err := NewWithEvict(2, onEvicted)
-	defer l.Close()
+	defer closeChecked(t, l)
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
@@ -342,3 +656,1366 @@ func TestLRUResize(t *testing.T) {
 	// 	t.Errorf("Cache should have contained 2 elements")
 	// }
 }
+
+// test that WithMaxOvershoot bounds the number of entries beyond capacity
+func TestLRUMaxOvershoot(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	const capacity, overshoot = 16, 4
+	const nInserters, nKeys = 4, 1024
+	var evictCounter int64
+	blocked, release := make(chan struct{}), make(chan struct{})
+	onEvicted := func(k interface{}, v interface{}) {
+		if atomic.AddInt64(&evictCounter, 1) == 1 {
+			// Stall the cleanup worker, so that only the bound limits Len
+			close(blocked)
+			<-release
+		}
+	}
+
+	l, err := NewWithEvict(capacity, onEvicted, WithMaxOvershoot(overshoot))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	for i := 0; i <= capacity; i++ {
+		l.Add(strconv.Itoa(i), i)
+	}
+	<-blocked
+
+	checkLen := func() {
+		if n := l.Len(); n > capacity+overshoot {
+			t.Errorf("Len %d exceeds capacity %d + overshoot %d", n, capacity, overshoot)
+		}
+	}
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for g := 0; g < nInserters; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < nKeys; i++ {
+				l.Add(fmt.Sprintf("%d-%d", g, i), i)
+				checkLen()
+			}
+		}(g)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			checkLen()
+			runtime.Gosched()
+		}
+	}
+	close(release)
+
+	const total = capacity + 1 + nInserters*nKeys
+	for atomic.LoadInt64(&evictCounter) < total-capacity {
+		// test times out if the evictions never happen
+		runtime.Gosched()
+	}
+	if l.Len() != capacity {
+		t.Errorf("bad len: %v", l.Len())
+	}
+}
+
+// test that PeekMulti doesn't update recent-ness
+func TestLRUPeekMulti(t *testing.T) {
+	l, err := New(2)
+	defer closeChecked(t, l)
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	l.Add("1", 1)
+	l.Add("2", 2)
+	values := l.PeekMulti([]string{"1", "2", "3"})
+	if len(values) != 2 || values["1"] != 1 || values["2"] != 2 {
+		t.Errorf("PeekMulti returned unexpected values: %v", values)
+	}
+
+	l.Add("3", 3)
+	for l.itemCount() > 2 {
+		// Wait for eviction to be handled
+		runtime.Gosched()
+	}
+	if l.Contains("1") {
+		t.Errorf("PeekMulti should not have updated recent-ness of 1")
+	}
+	if values := l.PeekMulti([]string{"1", "3"}); len(values) != 1 || values["3"] != 3 {
+		t.Errorf("PeekMulti returned unexpected values: %v", values)
+	}
+}
+
+// test that ContainsAll doesn't update recent-ness
+func TestLRUContainsAll(t *testing.T) {
+	l, err := New(2)
+	defer closeChecked(t, l)
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	if !l.ContainsAll(nil) {
+		t.Errorf("ContainsAll should hold for no keys")
+	}
+	l.Add("1", 1)
+	l.Add("2", 2)
+	if !l.ContainsAll([]string{"1", "2"}) {
+		t.Errorf("ContainsAll should find 1 and 2")
+	}
+	if l.ContainsAll([]string{"1", "3", "2"}) {
+		t.Errorf("ContainsAll should miss 3")
+	}
+
+	l.Add("3", 3)
+	for l.itemCount() > 2 {
+		// Wait for eviction to be handled
+		runtime.Gosched()
+	}
+	if l.ContainsAll([]string{"1"}) {
+		t.Errorf("ContainsAll should not have updated recent-ness of 1")
+	}
+}
+
+// test that GetAll updates recent-ness of the keys it finds
+func TestLRUGetAll(t *testing.T) {
+	l, err := New(2)
+	defer closeChecked(t, l)
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	l.Add("1", 1)
+	l.Add("2", 2)
+	l.DumpOrder() // Let the insertions settle
+	values := l.GetAll([]string{"1", "3"})
+	if len(values) != 1 || values["1"] != 1 {
+		t.Errorf("GetAll returned unexpected values: %v", values)
+	}
+	if m := l.Collect(); m.Hits != 1 || m.Misses != 1 {
+		t.Errorf("GetAll counted %d hits and %d misses, want 1 and 1", m.Hits, m.Misses)
+	}
+
+	l.Add("3", 3)
+	for l.itemCount() > 2 {
+		// Wait for eviction to be handled
+		runtime.Gosched()
+	}
+	if !l.Contains("1") || l.Contains("2") {
+		t.Errorf("GetAll should have updated recent-ness of 1")
+	}
+}
+
+// test that a cached nil value is distinguished from a missing key
+func TestLRUNilValue(t *testing.T) {
+	evicted := make(chan interface{}, 3) // Close evicts the rest
+	onEvicted := func(k interface{}, v interface{}) {
+		evicted <- v
+	}
+	l, err := NewWithEvict(1, onEvicted)
+	defer closeChecked(t, l)
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	l.Add("nil", nil)
+	if v, ok := l.Get("nil"); !ok || v != nil {
+		t.Errorf("Get(nil) = %v, %v, want nil, true", v, ok)
+	}
+	if v, ok := l.Peek("nil"); !ok || v != nil {
+		t.Errorf("Peek(nil) = %v, %v, want nil, true", v, ok)
+	}
+	if !l.Contains("nil") {
+		t.Errorf("nil value should be contained")
+	}
+	if v, ok := l.Get("missing"); ok || v != nil {
+		t.Errorf("Get(missing) = %v, %v, want nil, false", v, ok)
+	}
+	if v, ok := l.Peek("missing"); ok || v != nil {
+		t.Errorf("Peek(missing) = %v, %v, want nil, false", v, ok)
+	}
+	if l.Contains("missing") {
+		t.Errorf("missing should not be contained")
+	}
+
+	// Update to and from nil
+	l.Add("nil", 1)
+	if v, ok := l.Get("nil"); !ok || v != 1 {
+		t.Errorf("Get(nil) = %v, %v, want 1, true", v, ok)
+	}
+	l.Add("nil", nil)
+	if v, ok := l.Peek("nil"); !ok || v != nil {
+		t.Errorf("Peek(nil) = %v, %v, want nil, true", v, ok)
+	}
+	if l.Len() != 1 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+
+	// The latest value is reported on eviction
+	l.Add("other", 2)
+	if v := <-evicted; v != nil {
+		t.Errorf("evicted value = %v, want nil", v)
+	}
+	if l.Contains("nil") {
+		t.Errorf("nil should have been evicted")
+	}
+}
+
+// test that evictions make progress while entries are touched relentlessly
+func TestLRUEvictUnderTouches(t *testing.T) {
+	const capacity, nKeys = 64, 256
+	l, err := New(capacity)
+	defer closeChecked(t, l)
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			for i := nKeys - 1; i >= 0; i-- {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				l.Get(strconv.Itoa(i))
+			}
+		}
+	}()
+
+	for i := 0; i < nKeys; i++ {
+		is := strconv.Itoa(i)
+		l.Add(is, is)
+	}
+	for l.Len() > capacity {
+		// test times out if the evictions never happen
+		runtime.Gosched()
+	}
+	close(stop)
+	<-done
+
+	if l.Len() != capacity {
+		t.Errorf("bad len: %v", l.Len())
+	}
+}
+
+// test that evictions keep up with a relentless stream of insertions while
+// the oldest entries are touched, so that PopBack races against their moves
+func TestLRUEvictUnderInserts(t *testing.T) {
+	// The cleanup worker evicts through PopBackN, Add with a bounded
+	// overshoot through PopBack
+	t.Run("worker", func(t *testing.T) { testEvictUnderInserts(t) })
+	t.Run("overshoot", func(t *testing.T) { testEvictUnderInserts(t, WithMaxOvershoot(0)) })
+}
+
+func testEvictUnderInserts(t *testing.T, opts ...Option) {
+	const capacity, nKeys = 64, 1024
+	l, err := New(capacity, opts...)
+	defer closeChecked(t, l)
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+	l.evict.popAttempts = 0 // Always hold off the moves, see PopBack
+
+	inserted := int64(0)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// Touch the entries that are next in line for eviction
+			oldest := int(atomic.LoadInt64(&inserted)) - capacity
+			for i := oldest; i < oldest+4; i++ {
+				l.Get(strconv.Itoa(i))
+			}
+		}
+	}()
+
+	for i := 0; i < nKeys; i++ {
+		is := strconv.Itoa(i)
+		l.Add(is, is)
+		atomic.StoreInt64(&inserted, int64(i+1))
+	}
+	for l.Len() > capacity {
+		// test times out if the evictions never catch up with the touches
+		runtime.Gosched()
+	}
+	close(stop)
+	<-done
+
+	if l.Len() != capacity {
+		t.Errorf("bad len: %v", l.Len())
+	}
+}
+
+// test that SetCapacity validates its argument and evicts when downsizing
+func TestLRUSetCapacity(t *testing.T) {
+	evictCounter := int64(0)
+	onEvicted := func(k interface{}, v interface{}) {
+		atomic.AddInt64(&evictCounter, 1)
+	}
+	l, err := NewWithEvict(8, onEvicted)
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	if err := l.SetCapacity(0); err == nil {
+		t.Errorf("SetCapacity(0) should fail")
+	}
+	if err := l.SetCapacity(-1); err == nil {
+		t.Errorf("SetCapacity(-1) should fail")
+	}
+	if c := l.Cap(); c != 8 {
+		t.Errorf("Cap() = %d after invalid SetCapacity, want 8", c)
+	}
+
+	for i := 0; i < 8; i++ {
+		is := strconv.Itoa(i)
+		l.Add(is, is)
+	}
+
+	// Downsize
+	if err := l.SetCapacity(3); err != nil {
+		t.Errorf("err: %v", err)
+	}
+	if c := l.Cap(); c != 3 {
+		t.Errorf("Cap() = %d, want 3", c)
+	}
+	for atomic.LoadInt64(&evictCounter) < 5 {
+		// test times out if the evictions never happen
+		runtime.Gosched()
+	}
+	if l.Len() != 3 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+	for i := 5; i < 8; i++ {
+		if !l.Contains(strconv.Itoa(i)) {
+			t.Errorf("%d should not have been evicted", i)
+		}
+	}
+
+	// Upsize
+	if err := l.SetCapacity(5); err != nil {
+		t.Errorf("err: %v", err)
+	}
+	if a := l.Add("8", "8"); a {
+		t.Errorf("Add should not evict after upsizing")
+	}
+
+	l.Close()
+	if err := l.SetCapacity(5); err != ErrClosed {
+		t.Errorf("SetCapacity after Close = %v, want ErrClosed", err)
+	}
+}
+
+// test that a panicking eviction callback doesn't stop evictions
+func TestLRUEvictPanic(t *testing.T) {
+	evictCounter := int64(0)
+	onEvicted := func(k interface{}, v interface{}) {
+		atomic.AddInt64(&evictCounter, 1)
+		if k == "0" {
+			panic("evicting 0")
+		}
+	}
+	panicCounter := int64(0)
+	onPanic := func(key string, recovered interface{}) {
+		if key != "0" || recovered != "evicting 0" {
+			t.Errorf("unexpected panic for %s: %v", key, recovered)
+		}
+		atomic.AddInt64(&panicCounter, 1)
+	}
+
+	l, err := NewWithEvict(1, onEvicted, WithEvictPanicHandler(onPanic))
+	defer closeChecked(t, l)
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		is := strconv.Itoa(i)
+		l.Add(is, is)
+	}
+	for atomic.LoadInt64(&evictCounter) < 3 {
+		// test times out if evictions stop after the panic
+		runtime.Gosched()
+	}
+	if n := atomic.LoadInt64(&panicCounter); n != 1 {
+		t.Errorf("panic handler called %d times, want 1", n)
+	}
+	if !l.Contains("3") || l.Len() != 1 {
+		t.Errorf("only 3 should be left, Len() = %d", l.Len())
+	}
+}
+
+// test that Collect reports the counters of a known workload
+func TestLRUCollect(t *testing.T) {
+	l, err := New(2)
+	defer closeChecked(t, l)
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+	var _ Collector = l
+
+	l.Add("1", 1)
+	l.Add("2", 2)
+	l.Get("1")
+	l.Get("2")
+	l.Get("missing")
+	l.Add("3", 3)
+	for l.itemCount() > 2 {
+		// Wait for eviction to be handled
+		runtime.Gosched()
+	}
+	l.evict.waitForInsertions()
+
+	m := l.Collect()
+	want := Metrics{Len: 2, Cap: 2, Hits: 2, Misses: 1, Evictions: 1}
+	if m != want {
+		t.Errorf("Collect() = %+v, want %+v", m, want)
+	}
+}
+
+func TestLRUWarmup(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("b", 0)
+	l.evict.waitForInsertions()
+	entries := []Entry{{"a", 1}, {"b", 2}, {"c", 3}}
+	if l.Warmup(entries) {
+		t.Errorf("should not have an eviction")
+	}
+	l.evict.waitForInsertions()
+
+	want := []interface{}{"a", "b", "c"}
+	keys := l.Keys()
+	if len(keys) != len(want) {
+		t.Fatalf("bad keys: %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("bad keys: %v, want %v", keys, want)
+		}
+	}
+	if v, ok := l.Peek("b"); !ok || v != 2 {
+		t.Errorf("bad value for b: %v, %v", v, ok)
+	}
+	if l.Len() != 3 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+
+	if !l.Warmup([]Entry{{"d", 4}, {"e", 5}}) {
+		t.Errorf("should have an eviction")
+	}
+}
+
+func TestLRUDumpOrder(t *testing.T) {
+	l, err := New(3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.DumpOrder() // Let the insertions settle, so the updates below move them
+	l.Add("c", 3)
+	l.Get("a")
+	l.Add("b", 4)
+
+	want := []string{"b", "a", "c"}
+	order := l.DumpOrder()
+	if len(order) != len(want) {
+		t.Fatalf("DumpOrder() = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("DumpOrder() = %v, want %v", order, want)
+		}
+	}
+	if s := l.String(); s != "[b(0) a(1) c(2)]" {
+		t.Errorf("String() = %q, want %q", s, "[b(0) a(1) c(2)]")
+	}
+}
+
+func TestLRUTryGet(t *testing.T) {
+	l, err := New(3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.Add("c", 3)
+	l.DumpOrder() // Let the insertions settle, so the bump below moves a
+	v, ok, bumped := l.TryGet("a")
+	if !ok || v != 1 || !bumped {
+		t.Fatalf("TryGet(a) = %v, %v, %v, want 1, true, true", v, ok, bumped)
+	}
+	if order := fmt.Sprint(l.DumpOrder()); order != "[a c b]" {
+		t.Errorf("DumpOrder() after TryGet(a) = %v, want [a c b]", order)
+	}
+	if v, ok, bumped := l.TryGet("d"); ok || v != nil || bumped {
+		t.Errorf("TryGet(d) = %v, %v, %v, want nil, false, false", v, ok, bumped)
+	}
+
+	// Values are right whether or not the bumps get through
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := []string{"a", "b", "c"}[i%3]
+				if v, ok, _ := l.TryGet(key); !ok || v != i%3+1 {
+					t.Errorf("TryGet(%s) = %v, %v, want %d, true", key, v, ok, i%3+1)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if m := l.Collect(); m.Hits != 8001 || m.Misses != 1 {
+		t.Errorf("Collect() = %+v, want 8001 hits and 1 miss", m)
+	}
+}
+
+func TestLRUWithValue(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	increment := func(v interface{}) interface{} { return v.(int) + 1 }
+	if l.WithValue("a", increment) {
+		t.Errorf("WithValue of missing key = true, want false")
+	}
+	if l.Contains("a") || l.Len() != 0 || l.itemCount() != 0 {
+		t.Errorf("WithValue of missing key added it")
+	}
+
+	l.Add("a", 0)
+	const goroutines, increments = 8, 1000
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				if !l.WithValue("a", increment) {
+					t.Errorf("WithValue of present key = false, want true")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, ok := l.Peek("a"); !ok || v != goroutines*increments {
+		t.Errorf("Peek(a) = %v, %v, want %d, true", v, ok, goroutines*increments)
+	}
+	if l.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", l.Len())
+	}
+}
+
+func TestLRUCompareAndSwap(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	eq := func(a, b interface{}) bool { return a == b }
+	if l.CompareAndSwap("a", 0, 1, eq) {
+		t.Errorf("CompareAndSwap of missing key = true, want false")
+	}
+	if l.Contains("a") || l.Len() != 0 || l.itemCount() != 0 {
+		t.Errorf("CompareAndSwap of missing key added it")
+	}
+
+	l.Add("a", 0)
+	if l.CompareAndSwap("a", 1, 2, eq) {
+		t.Errorf("CompareAndSwap with wrong old value = true, want false")
+	}
+	if v, _ := l.Peek("a"); v != 0 {
+		t.Errorf("Peek(a) after failed swap = %v, want 0", v)
+	}
+
+	// Competing swaps from the same old value: exactly one wins
+	const goroutines = 8
+	var wg sync.WaitGroup
+	var wins, winner int64
+	for g := 1; g <= goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			if l.CompareAndSwap("a", 0, g, eq) {
+				atomic.AddInt64(&wins, 1)
+				atomic.StoreInt64(&winner, int64(g))
+			}
+		}(g)
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Fatalf("%d competing swaps won, want 1", wins)
+	}
+	if v, ok := l.Peek("a"); !ok || v != int(winner) {
+		t.Errorf("Peek(a) = %v, %v, want %d, true", v, ok, winner)
+	}
+}
+
+func TestLRUMerge(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+	other, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, other)
+
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.DumpOrder()
+	for i, key := range []string{"b", "c", "d", "e"} {
+		other.Add(key, 10*(i+2))
+		other.DumpOrder() // Let the insertions settle in order
+	}
+
+	sum := func(key string, existing, incoming interface{}) interface{} {
+		return existing.(int) + incoming.(int)
+	}
+	l.Merge(other, sum)
+	l.Flush()
+
+	// The merged entries are newer than a, which is evicted
+	if order := fmt.Sprint(l.DumpOrder()); order != "[e d c b]" {
+		t.Errorf("DumpOrder() after Merge = %v, want [e d c b]", order)
+	}
+	if values := fmt.Sprint(l.SnapshotMap()); values != "map[b:22 c:30 d:40 e:50]" {
+		t.Errorf("values after Merge = %v, want map[b:22 c:30 d:40 e:50]", values)
+	}
+	if l.Len() != 4 {
+		t.Errorf("bad len after Merge: %v", l.Len())
+	}
+	if values := fmt.Sprint(other.SnapshotMap()); values != "map[b:20 c:30 d:40 e:50]" {
+		t.Errorf("Merge changed other to %v", values)
+	}
+
+	// Without onConflict, the incoming values win
+	other.Add("c", 31)
+	l.Merge(other, nil)
+	l.Flush()
+	if values := fmt.Sprint(l.SnapshotMap()); values != "map[b:20 c:31 d:40 e:50]" {
+		t.Errorf("values after Merge without onConflict = %v, want map[b:20 c:31 d:40 e:50]", values)
+	}
+}
+
+func TestLRURangeAndTouch(t *testing.T) {
+	l, err := New(5)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.Add("c", 3)
+	l.Add("d", 4)
+	l.DumpOrder() // Let the insertions settle, so the uses below move them
+	l.Get("a")
+	l.DumpOrder()
+
+	// Touching all entries keeps their order
+	var visited []string
+	l.RangeAndTouch(func(key string, value interface{}) bool {
+		visited = append(visited, fmt.Sprint(key, value))
+		return true
+	})
+	if fmt.Sprint(visited) != "[b2 c3 d4 a1]" {
+		t.Errorf("RangeAndTouch visited %v, want [b2 c3 d4 a1]", visited)
+	}
+	if order := fmt.Sprint(l.DumpOrder()); order != "[a d c b]" {
+		t.Errorf("DumpOrder() after touching all = %v, want [a d c b]", order)
+	}
+
+	// Stopping early only touches the entries visited
+	visited = nil
+	l.RangeAndTouch(func(key string, value interface{}) bool {
+		visited = append(visited, key)
+		return len(visited) < 2
+	})
+	if fmt.Sprint(visited) != "[b c]" {
+		t.Errorf("RangeAndTouch stopping after 2 visited %v, want [b c]", visited)
+	}
+	if order := fmt.Sprint(l.DumpOrder()); order != "[c b a d]" {
+		t.Errorf("DumpOrder() after touching b and c = %v, want [c b a d]", order)
+	}
+
+	// f may use the cache, and entries evicted before their visit are skipped
+	visited = nil
+	l.RangeAndTouch(func(key string, value interface{}) bool {
+		visited = append(visited, key)
+		if key == "d" {
+			l.Add("e", 5)
+			l.Add("f", 6) // Evicts the oldest entry, a
+			l.Flush()
+		}
+		return true
+	})
+	if fmt.Sprint(visited) != "[d b c]" {
+		t.Errorf("RangeAndTouch with eviction visited %v, want [d b c]", visited)
+	}
+}
+
+func TestLRUKeysMatching(t *testing.T) {
+	l, err := New(10)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	for _, key := range []string{"user:1", "user:2", "item:1", "user:3", "item:2"} {
+		l.Add(key, key)
+	}
+	l.Get("item:1")
+	order := l.DumpOrder()
+
+	keys := l.KeysMatching(func(key string) bool { return strings.HasPrefix(key, "user:") })
+	sort.Strings(keys)
+	if fmt.Sprint(keys) != "[user:1 user:2 user:3]" {
+		t.Errorf("KeysMatching(user:) = %v, want [user:1 user:2 user:3]", keys)
+	}
+	if keys := l.KeysMatching(func(string) bool { return false }); len(keys) != 0 {
+		t.Errorf("KeysMatching(none) = %v, want none", keys)
+	}
+	if after := l.DumpOrder(); fmt.Sprint(after) != fmt.Sprint(order) {
+		t.Errorf("KeysMatching changed the order from %v to %v", order, after)
+	}
+}
+
+func TestLRUSnapshotMap(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	if values := l.SnapshotMap(); len(values) != 0 {
+		t.Errorf("SnapshotMap() of empty cache = %v, want none", values)
+	}
+	for i := 0; i < 6; i++ {
+		l.Add(strconv.Itoa(i), i)
+		l.Flush()
+	}
+	l.Add("3", 30)
+	order := l.DumpOrder()
+
+	// 0 and 1 were evicted
+	values := l.SnapshotMap()
+	if fmt.Sprint(values) != "map[2:2 3:30 4:4 5:5]" {
+		t.Errorf("SnapshotMap() = %v, want map[2:2 3:30 4:4 5:5]", values)
+	}
+	if after := l.DumpOrder(); fmt.Sprint(after) != fmt.Sprint(order) {
+		t.Errorf("SnapshotMap changed the order from %v to %v", order, after)
+	}
+
+	// The snapshot is a copy
+	values["6"] = 6
+	if l.Contains("6") {
+		t.Errorf("changing the snapshot changed the cache")
+	}
+}
+
+func TestLRUCheckInvariants(t *testing.T) {
+	l, err := New(3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("a", 1)
+	l.Add("b", 2)
+	if err := l.checkInvariants(); err != nil {
+		t.Fatalf("checkInvariants() = %v, want nil", err)
+	}
+
+	// Each desync is undone again, so the cache can be closed
+	atomic.AddInt64(&l.len, 1)
+	if err := l.checkInvariants(); err == nil {
+		t.Errorf("checkInvariants() with wrong len = nil, want error")
+	}
+	atomic.AddInt64(&l.len, -1)
+
+	v, _ := l.shard("a").Pop("a")
+	if err := l.checkInvariants(); err == nil {
+		t.Errorf("checkInvariants() with entry missing from map = nil, want error")
+	}
+	l.shard("a").Set("a", v)
+
+	a := v.(*item)
+	l.shard("a").Set("a", &item{key: "a", value: 1, evictElement: &element{}})
+	if err := l.checkInvariants(); err == nil {
+		t.Errorf("checkInvariants() with element missing from evict list = nil, want error")
+	}
+	l.shard("a").Set("a", a)
+
+	b, _ := l.shard("b").Get("b")
+	l.shard("a").Set("a", &item{key: "a", value: 1, evictElement: b.(*item).evictElement})
+	if err := l.checkInvariants(); err == nil {
+		t.Errorf("checkInvariants() with shared element = nil, want error")
+	}
+	l.shard("a").Set("a", a)
+}
+
+func TestLRUGetOldestN(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.Add("c", 3)
+	l.Add("d", 4)
+	l.DumpOrder() // Let the insertions settle, so the use below moves a
+	l.Get("a")
+	l.WithValue("c", func(interface{}) interface{} { return 30 })
+	order := l.DumpOrder()
+
+	checkEntries := func(n int, want string) {
+		t.Helper()
+		if entries := fmt.Sprint(l.GetOldestN(n)); entries != want {
+			t.Errorf("GetOldestN(%d) = %s, want %s", n, entries, want)
+		}
+	}
+	checkEntries(2, "[{b 2} {c 30}]")
+	checkEntries(10, "[{b 2} {c 30} {d 4} {a 1}]")
+	checkEntries(0, "[]")
+	if after := l.DumpOrder(); fmt.Sprint(after) != fmt.Sprint(order) {
+		t.Errorf("GetOldestN changed the order from %v to %v", order, after)
+	}
+}
+
+func TestLRUEvictPool(t *testing.T) {
+	var running, maxRunning, evictions int64
+	onEvicted := func(k interface{}, v interface{}) {
+		n := atomic.AddInt64(&running, 1)
+		for m := atomic.LoadInt64(&maxRunning); n > m; m = atomic.LoadInt64(&maxRunning) {
+			if atomic.CompareAndSwapInt64(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond) // A slow callback, e.g. writing to disk
+		atomic.AddInt64(&running, -1)
+		atomic.AddInt64(&evictions, 1)
+	}
+	l, err := NewWithEvict(1, onEvicted, WithEvictPool(4))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 9; i++ {
+		l.Add(strconv.Itoa(i), i)
+	}
+	l.Flush()
+	if n := atomic.LoadInt64(&evictions); n != 8 {
+		t.Errorf("%d evictions after Flush, want 8", n)
+	}
+	if m := atomic.LoadInt64(&maxRunning); m < 2 || m > 4 {
+		t.Errorf("at most %d callbacks ran concurrently, want 2 to 4", m)
+	}
+	closeChecked(t, l)
+	if n := atomic.LoadInt64(&evictions); n != 9 {
+		t.Errorf("%d evictions after Close, want 9", n)
+	}
+}
+
+func TestLRULoad(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if load := l.Load(); load != 0 || l.IsFull() {
+		t.Errorf("empty cache: Load() = %v, IsFull() = %v", load, l.IsFull())
+	}
+	l.Add("1", 1)
+	l.Add("2", 2)
+	if load := l.Load(); load != 0.5 || l.IsFull() {
+		t.Errorf("half full cache: Load() = %v, IsFull() = %v", load, l.IsFull())
+	}
+	l.Add("3", 3)
+	l.Add("4", 4)
+	if load := l.Load(); load != 1 || !l.IsFull() {
+		t.Errorf("full cache: Load() = %v, IsFull() = %v", load, l.IsFull())
+	}
+	l.Add("5", 5) // over capacity until the eviction completes
+	if load := l.Load(); load != 1 || !l.IsFull() {
+		t.Errorf("overfull cache: Load() = %v, IsFull() = %v", load, l.IsFull())
+	}
+
+	l.Close()
+	if load := l.Load(); load != 1 || !l.IsFull() {
+		t.Errorf("closed cache: Load() = %v, IsFull() = %v", load, l.IsFull())
+	}
+}
+
+func TestLRUClosed(t *testing.T) {
+	var evictions int64
+	onEvicted := func(k interface{}, v interface{}) {
+		atomic.AddInt64(&evictions, 1)
+	}
+	l, err := NewWithEvict(4, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.Add("a", 1)
+	l.Close()
+	l.Close() // Closing again does nothing
+
+	if l.Add("b", 2) {
+		t.Errorf("Add after Close = true, want false")
+	}
+	if _, _, evicted := l.AddReturningEvicted("c", 3); evicted {
+		t.Errorf("AddReturningEvicted after Close reported an eviction")
+	}
+	l.Warmup([]Entry{{"d", 4}, {"e", 5}})
+	if v, ok := l.Get("b"); ok {
+		t.Errorf("Get(b) after Close = %v, true, want a miss", v)
+	}
+	if _, ok, bumped := l.TryGet("a"); ok || bumped {
+		t.Errorf("TryGet(a) after Close found the entry")
+	}
+	if l.Remove("a") {
+		t.Errorf("Remove(a) after Close found the entry")
+	}
+	if l.Len() != 0 || l.itemCount() != 0 {
+		t.Errorf("closed cache holds %d entries, %d in its map, want none", l.Len(), l.itemCount())
+	}
+	if err := l.SetCapacity(4); err != ErrClosed {
+		t.Errorf("SetCapacity after Close = %v, want ErrClosed", err)
+	}
+	if n := atomic.LoadInt64(&evictions); n != 1 {
+		t.Errorf("%d evictions, want 1 for the entry added before Close", n)
+	}
+}
+
+// refLRU is a simple, sequential LRU cache that serves as the reference for
+// the eviction order of LRU.
+type refLRU struct {
+	capacity int
+	order    *clist.List // Keys from most to least recently used
+	entries  map[string]*clist.Element
+	evicted  []string
+}
+
+func newRefLRU(capacity int) *refLRU {
+	return &refLRU{
+		capacity: capacity,
+		order:    clist.New(),
+		entries:  make(map[string]*clist.Element),
+	}
+}
+
+func (r *refLRU) Add(key string) {
+	if e, ok := r.entries[key]; ok {
+		r.order.MoveToFront(e)
+		return
+	}
+	r.entries[key] = r.order.PushFront(key)
+	if r.order.Len() > r.capacity {
+		oldest := r.order.Remove(r.order.Back()).(string)
+		delete(r.entries, oldest)
+		r.evicted = append(r.evicted, oldest)
+	}
+}
+
+func (r *refLRU) Get(key string) bool {
+	e, ok := r.entries[key]
+	if ok {
+		r.order.MoveToFront(e)
+	}
+	return ok
+}
+
+// Operation of a trace: Add if add is set, otherwise Get
+type traceOp struct {
+	add bool
+	key string
+}
+
+// parseTrace parses a trace like "+a +b a", where +k adds k and k gets it.
+func parseTrace(trace string) []traceOp {
+	var ops []traceOp
+	for _, field := range strings.Fields(trace) {
+		if strings.HasPrefix(field, "+") {
+			ops = append(ops, traceOp{add: true, key: field[1:]})
+		} else {
+			ops = append(ops, traceOp{key: field})
+		}
+	}
+	return ops
+}
+
+// replayTrace replays ops on l and on a refLRU of the same capacity, letting
+// l settle after each operation, and checks that they agree on the hits, the
+// eviction order and the survivors.
+func replayTrace(t *testing.T, name string, newLRU func(size int, onEvict func(k, v interface{})) (*LRU, error), capacity int, ops []traceOp) {
+	t.Helper()
+	var evicted []string
+	l, err := newLRU(capacity, func(k, v interface{}) {
+		evicted = append(evicted, k.(string)) // Only one eviction at a time, see below
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	ref := newRefLRU(capacity)
+	for i, op := range ops {
+		if op.add {
+			l.Add(op.key, i)
+			ref.Add(op.key)
+		} else if _, ok := l.Get(op.key); ok != ref.Get(op.key) {
+			t.Errorf("%s: op %d: Get(%s) = %v, reference %v", name, i, op.key, ok, !ok)
+		}
+		l.Flush()
+		l.evict.waitForInsertions()
+	}
+
+	if fmt.Sprint(evicted) != fmt.Sprint(ref.evicted) {
+		t.Errorf("%s: evicted %v, reference %v", name, evicted, ref.evicted)
+	}
+	var survivors []string
+	for e := ref.order.Front(); e != nil; e = e.Next() {
+		survivors = append(survivors, e.Value.(string))
+	}
+	if order := l.DumpOrder(); l.policy == nil && fmt.Sprint(order) != fmt.Sprint(survivors) {
+		t.Errorf("%s: survivors %v, reference %v", name, order, survivors)
+	}
+	for _, key := range survivors {
+		if !l.Contains(key) {
+			t.Errorf("%s: survivor %s missing", name, key)
+		}
+	}
+	if l.Len() != len(survivors) {
+		t.Errorf("%s: %d survivors, reference %d", name, l.Len(), len(survivors))
+	}
+}
+
+// Checks the eviction order against refLRU. Changes of the eviction order
+// must keep this test passing, or justify changing the reference.
+func TestLRUEvictionOrderOracle(t *testing.T) {
+	// Hot keys a and b are used often, then a scan of keys used once each
+	// pushes them out, as LRU is not scan resistant
+	var scan strings.Builder
+	scan.WriteString("+a +b a b a b +c a b ")
+	for i := 0; i < 6; i++ {
+		fmt.Fprintf(&scan, "+s%d ", i)
+	}
+	scan.WriteString("a b")
+
+	// Random adds and gets over a small key space
+	r := rand.New(rand.NewSource(1))
+	var random strings.Builder
+	for i := 0; i < 500; i++ {
+		if r.Intn(2) == 0 {
+			random.WriteByte('+')
+		}
+		fmt.Fprintf(&random, "k%d ", r.Intn(12))
+	}
+
+	traces := []struct {
+		name     string
+		capacity int
+		trace    string
+	}{
+		{"basic", 3, "+a +b +c a +d +e d +f b c d e f"},
+		{"updates", 2, "+a +b +a +c a b c +b +d"},
+		{"scan", 4, scan.String()},
+		{"random", 5, random.String()},
+	}
+	for _, tr := range traces {
+		replayTrace(t, tr.name, func(size int, onEvict func(k, v interface{})) (*LRU, error) {
+			return NewWithEvict(size, onEvict, WithStrictEviction(true))
+		}, tr.capacity, parseTrace(tr.trace))
+		replayTrace(t, tr.name+" with LRU policy", func(size int, onEvict func(k, v interface{})) (*LRU, error) {
+			return NewWithPolicy(size, NewLRUPolicy(), onEvict)
+		}, tr.capacity, parseTrace(tr.trace))
+	}
+}
+
+// Evict after using an entry whose insertion was still pending, returning
+// the key that was evicted
+func evictAfterPendingUse(t *testing.T, strict bool) interface{} {
+	evicted := make(chan interface{}, 3) // Close evicts the rest
+	onEvicted := func(k interface{}, v interface{}) {
+		evicted <- k
+	}
+	l, err := NewWithEvict(2, onEvicted, WithStrictEviction(strict))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	// Hold off the insertions by blocking the front of the eviction order
+	l.evict.head.mutex.Lock()
+	l.Add("x", 1)
+	l.Add("y", 2)
+	waiting := make(chan struct{})
+	var once sync.Once
+	l.evict.onWaitForInsertion = func() { once.Do(func() { close(waiting) }) }
+	got := make(chan bool)
+	go func() {
+		_, ok := l.Get("x")
+		got <- ok
+	}()
+	if !strict {
+		<-got
+	} else {
+		<-waiting // Let Get wait for the insertion of x
+	}
+	l.evict.head.mutex.Unlock()
+	if strict && !<-got {
+		t.Errorf("x should be found")
+	}
+
+	l.Add("z", 3)
+	return <-evicted
+}
+
+func TestLRUStrictEviction(t *testing.T) {
+	// x was used after y was added, so y should be evicted
+	if k := evictAfterPendingUse(t, true); k != "y" {
+		t.Errorf("strict eviction evicted %v, want y", k)
+	}
+	// Without strict eviction, x stays behind y
+	if k := evictAfterPendingUse(t, false); k != "x" {
+		t.Errorf("lax eviction evicted %v, want x", k)
+	}
+}
+
+func TestLRUStrictEvictionSequence(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		evicted := make(chan interface{}, 3) // Close evicts the rest
+		onEvicted := func(k interface{}, v interface{}) {
+			evicted <- k
+		}
+		l, err := NewWithEvict(2, onEvicted, WithStrictEviction(true))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		// Add a and b at the same time
+		var wg sync.WaitGroup
+		for _, key := range []string{"a", "b"} {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				l.Add(key, 0)
+			}(key)
+		}
+		wg.Wait()
+		l.DumpOrder() // Let the insertions settle
+		seq := func(key string) uint64 {
+			v, _ := l.shard(key).Get(key)
+			e := v.(*item).evictElement
+			e.mutex.Lock()
+			defer e.mutex.Unlock()
+			return e.seq
+		}
+		first := "a"
+		if seq("b") < seq("a") {
+			first = "b"
+		}
+
+		l.Add("c", 0)
+		if k := <-evicted; k != first {
+			t.Errorf("round %d: evicted %v, want %v, which was added first", round, k, first)
+		}
+		closeChecked(t, l)
+	}
+}
+
+func TestLRUSyncEvict(t *testing.T) {
+	const capacity = 4
+	var evictCounter int64
+	onEvicted := func(k interface{}, v interface{}) {
+		runtime.Gosched() // Give Add a chance to return early
+		atomic.AddInt64(&evictCounter, 1)
+	}
+	// The eviction pool is ignored, so the callbacks still run before Add returns
+	l, err := NewSyncEvict(capacity, onEvicted, WithEvictPool(2))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	for i := 0; i < 64; i++ {
+		evicted := l.Add(strconv.Itoa(i), i)
+		wantEvicted, wantLen := int64(0), i+1
+		if i >= capacity {
+			wantEvicted, wantLen = int64(i-capacity+1), capacity
+		}
+		if n := atomic.LoadInt64(&evictCounter); n != wantEvicted {
+			t.Fatalf("evicted %d entries after Add %d, want %d", n, i, wantEvicted)
+		}
+		if evicted != (i >= capacity) {
+			t.Errorf("Add %d reported eviction %v", i, evicted)
+		}
+		if l.Len() != wantLen {
+			t.Errorf("bad len after Add %d: %v, want %v", i, l.Len(), wantLen)
+		}
+	}
+
+	entries := []Entry{{"a", 1}, {"b", 2}, {"c", 3}}
+	before := atomic.LoadInt64(&evictCounter)
+	if !l.Warmup(entries) {
+		t.Errorf("Warmup should report an eviction")
+	}
+	if n := atomic.LoadInt64(&evictCounter) - before; n != 3 {
+		t.Errorf("evicted %d entries after Warmup, want 3", n)
+	}
+}
+
+func TestLRUTrimToSize(t *testing.T) {
+	const capacity = 10
+	var evictCounter int64
+	blocked, release := make(chan struct{}), make(chan struct{})
+	onEvicted := func(k interface{}, v interface{}) {
+		if atomic.AddInt64(&evictCounter, 1) == 1 {
+			// Stall the cleanup worker so the cache overfills
+			close(blocked)
+			<-release
+		}
+	}
+	l, err := NewWithEvict(capacity, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	for i := 0; i <= capacity; i++ {
+		l.Add(strconv.Itoa(i), i)
+	}
+	<-blocked
+	for i := capacity + 1; i <= capacity+20; i++ {
+		l.Add(strconv.Itoa(i), i)
+	}
+	if l.Len() <= capacity {
+		t.Fatalf("cache not overfilled: len %v", l.Len())
+	}
+
+	// TrimToSize evicts the excess itself while the worker is still stalled
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.TrimToSize()
+	}()
+	for atomic.LoadInt64(&evictCounter) < 21 {
+		// test times out if TrimToSize leaves the evictions to the worker
+		runtime.Gosched()
+	}
+	if l.Len() != capacity {
+		t.Errorf("bad len after TrimToSize: %v, want %v", l.Len(), capacity)
+	}
+	select {
+	case <-done:
+		t.Errorf("TrimToSize returned before the stalled eviction completed")
+	default:
+	}
+
+	close(release)
+	<-done
+	if n := atomic.LoadInt64(&evictCounter); n != 21 {
+		t.Errorf("evicted %d entries after TrimToSize, want 21", n)
+	}
+	for i := 0; i <= 20; i++ {
+		if l.Contains(strconv.Itoa(i)) {
+			t.Errorf("%d should have been evicted", i)
+		}
+	}
+}
+
+func TestLRUFlush(t *testing.T) {
+	var evictCounter int64
+	onEvicted := func(k interface{}, v interface{}) {
+		runtime.Gosched() // Give Flush a chance to return early
+		atomic.AddInt64(&evictCounter, 1)
+	}
+	l, err := NewWithEvict(10, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	for i := 0; i < 10; i++ {
+		l.Add(strconv.Itoa(i), i)
+	}
+	l.Flush()
+	if n := atomic.LoadInt64(&evictCounter); n != 0 {
+		t.Errorf("evicted %d entries within capacity", n)
+	}
+
+	if err := l.SetCapacity(4); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.Flush()
+	if n := atomic.LoadInt64(&evictCounter); n != 6 {
+		t.Errorf("evicted %d entries after Flush, want 6", n)
+	}
+	if l.Len() != 4 {
+		t.Errorf("bad len after Flush: %v", l.Len())
+	}
+
+	// Purge a cache whose callbacks run in a pool, so Flush has to wait for
+	// the ones that are still queued
+	purged := int64(0)
+	onPurged := func(k interface{}, v interface{}) {
+		time.Sleep(time.Millisecond)
+		atomic.AddInt64(&purged, 1)
+	}
+	p, err := NewWithEvict(10, onPurged, WithEvictPool(2))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, p)
+
+	for i := 0; i < 10; i++ {
+		p.Add(strconv.Itoa(i), i)
+	}
+	n := 0
+	for i := 0; i < 10; i++ {
+		if p.Remove(strconv.Itoa(i)) {
+			n++
+		}
+	}
+	p.Flush()
+	if got := atomic.LoadInt64(&purged); got != int64(n) || n != 10 {
+		t.Errorf("evicted %d of %d purged entries after Flush, want 10", got, n)
+	}
+}
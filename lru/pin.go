@@ -0,0 +1,97 @@
+package lru
+
+import "sync/atomic"
+
+// Pin marks key as in use, so that its entry is not evicted: evictions skip
+// pinned entries and evict the least recently used unpinned entry instead.
+// If all entries are pinned, the cache exceeds its capacity until some of
+// them are unpinned or removed. The mark applies to key whether or not it is
+// in the cache, and stays until Unpin is called; pinning a key twice has no
+// further effect. Close evicts pinned entries as well, and a cache created by
+// NewWithPolicy ignores pins.
+func (c *LRU) Pin(key string) {
+	c.pinMutex.Lock()
+	defer c.pinMutex.Unlock()
+	if _, ok := c.pins[key]; !ok {
+		c.pins[key] = struct{}{}
+		atomic.AddInt64(&c.nPins, 1)
+	}
+}
+
+// Unpin removes the mark that Pin set on key, so that its entry can be
+// evicted again. If the cache is over capacity, the excess entries are
+// evicted in the background.
+func (c *LRU) Unpin(key string) {
+	c.pinMutex.Lock()
+	_, ok := c.pins[key]
+	if ok {
+		delete(c.pins, key)
+		atomic.AddInt64(&c.nPins, -1)
+	}
+	c.pinMutex.Unlock()
+
+	if ok {
+		c.cleanup.L.Lock()
+		c.evictionsUnblocked()
+		c.cleanup.Signal()
+		c.cleanup.L.Unlock()
+	}
+}
+
+// pinnedElement reports whether the entry of e, an element of the evict
+// list, is pinned.
+func (c *LRU) pinnedElement(e *element) bool {
+	c.pinMutex.RLock()
+	defer c.pinMutex.RUnlock()
+	_, ok := c.pins[e.Value.(*item).key]
+	return ok
+}
+
+// popOldest removes the least recently used element from the evict list
+// whose entry is not pinned, and returns it, or nil if there is none. It also
+// reports whether it found none because only pinned entries are left, with
+// no insertions pending that could be evicted instead. Then blockedByPins
+// holds until the next change that may let evictions proceed.
+func (c *LRU) popOldest() (*element, bool) {
+	if atomic.LoadInt64(&c.nPins) == 0 || c.closed() {
+		return c.evict.PopBack(), false
+	}
+	epoch := atomic.LoadInt64(&c.evictEpoch)
+	e, skipped := c.evict.PopBackUnless(c.pinnedElement)
+	if e == nil && skipped && atomic.LoadInt64(&c.evict.nPendingInsertions) == 0 {
+		// If anything changed since we started, blockedByPins won't hold
+		atomic.StoreInt64(&c.pinBlocked, epoch)
+		return nil, true
+	}
+	return e, false
+}
+
+// popOldestN is like popOldest, but removes up to n elements, which it
+// returns from back to front like PopBackN.
+func (c *LRU) popOldestN(n int) ([]*element, bool) {
+	if atomic.LoadInt64(&c.nPins) == 0 || c.closed() {
+		return c.evict.PopBackN(n), false
+	}
+	var es []*element
+	for len(es) < n {
+		e, blocked := c.popOldest()
+		if e == nil {
+			return es, blocked
+		}
+		es = append(es, e)
+	}
+	return es, false
+}
+
+// evictionsUnblocked records a change that may let evictions proceed that
+// found only pinned entries left, like an insertion or an Unpin, so that
+// blockedByPins no longer holds. The caller must hold c.cleanup.L.
+func (c *LRU) evictionsUnblocked() {
+	atomic.AddInt64(&c.evictEpoch, 1)
+}
+
+// blockedByPins reports whether an eviction found only pinned entries left
+// to evict, and nothing changed since that may let evictions proceed.
+func (c *LRU) blockedByPins() bool {
+	return atomic.LoadInt64(&c.pinBlocked) == atomic.LoadInt64(&c.evictEpoch)
+}
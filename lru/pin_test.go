@@ -0,0 +1,85 @@
+package lru
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// checkKeys checks that l holds exactly keys, in any order
+func checkKeys(t *testing.T, l *LRU, keys ...string) {
+	t.Helper()
+	got := l.KeysMatching(func(string) bool { return true })
+	sort.Strings(got)
+	sort.Strings(keys)
+	if fmt.Sprint(got) != fmt.Sprint(keys) {
+		t.Errorf("cache holds %v, want %v", got, keys)
+	}
+	if l.Len() != len(keys) {
+		t.Errorf("bad len: %v, want %v", l.Len(), len(keys))
+	}
+}
+
+func TestLRUPin(t *testing.T) {
+	l, err := New(3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.Add("c", 3)
+	l.DumpOrder() // Let the insertions settle, so a is the oldest
+
+	// The next oldest entry is evicted in place of the pinned one
+	l.Pin("a")
+	l.Add("d", 4)
+	l.Flush()
+	checkKeys(t, l, "a", "c", "d")
+
+	// If all entries are pinned, the cache exceeds its capacity
+	l.Pin("c")
+	l.Pin("d")
+	l.Pin("e") // Keys can be pinned before they are added
+	l.Add("e", 5)
+	l.Flush()
+	checkKeys(t, l, "a", "c", "d", "e")
+	l.TrimToSize()
+	checkKeys(t, l, "a", "c", "d", "e")
+	if key, _, evicted := l.AddReturningEvicted("f", 6); !evicted || key != "f" {
+		t.Errorf("AddReturningEvicted(f) evicted %v, %v, want f, true", key, evicted)
+	}
+	checkKeys(t, l, "a", "c", "d", "e")
+
+	// Unpinning lets the excess entries be evicted again, oldest first
+	l.Unpin("a")
+	l.Unpin("a") // Pins don't nest
+	l.Flush()
+	checkKeys(t, l, "c", "d", "e")
+	l.Unpin("c")
+	l.Unpin("d")
+	l.Unpin("e")
+	l.Add("g", 7)
+	l.Flush()
+	checkKeys(t, l, "d", "e", "g")
+}
+
+func TestLRUPinClose(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.Pin("a")
+	l.Pin("b")
+	l.Pin("c")
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.Add("c", 3)
+	l.Flush()
+	checkKeys(t, l, "a", "b", "c")
+
+	// Closing evicts pinned entries too
+	closeChecked(t, l)
+	checkKeys(t, l)
+}
@@ -0,0 +1,131 @@
+package lru
+
+import clist "container/list"
+
+// EvictionPolicy decides which entry a cache created by NewWithPolicy evicts.
+// The cache serialises all calls, so implementations need not be thread-safe.
+type EvictionPolicy interface {
+	// Record registers a use of key, which is new if it isn't tracked yet.
+	Record(key string)
+	// Victim returns the key to evict next, or false if no key is tracked.
+	// It does not stop tracking the key, the cache calls Remove for that.
+	Victim() (key string, ok bool)
+	// Remove stops tracking key.
+	Remove(key string)
+}
+
+// lruPolicy evicts the least recently used key.
+type lruPolicy struct {
+	order   *clist.List // Keys from most to least recently used
+	entries map[string]*clist.Element
+}
+
+// NewLRUPolicy returns an EvictionPolicy that evicts the least recently used
+// key, like the default eviction order of LRU.
+func NewLRUPolicy() EvictionPolicy {
+	return &lruPolicy{
+		order:   clist.New(),
+		entries: make(map[string]*clist.Element),
+	}
+}
+
+func (p *lruPolicy) Record(key string) {
+	if e, ok := p.entries[key]; ok {
+		p.order.MoveToFront(e)
+		return
+	}
+	p.entries[key] = p.order.PushFront(key)
+}
+
+func (p *lruPolicy) Victim() (string, bool) {
+	if e := p.order.Back(); e != nil {
+		return e.Value.(string), true
+	}
+	return "", false
+}
+
+func (p *lruPolicy) Remove(key string) {
+	if e, ok := p.entries[key]; ok {
+		p.order.Remove(e)
+		delete(p.entries, key)
+	}
+}
+
+// lfuPolicy evicts the least frequently used key.
+type lfuPolicy struct {
+	// Keys by their number of uses, each from most to least recently used
+	buckets map[int]*clist.List
+	entries map[string]*clist.Element // Values are *lfuEntry
+
+	// At most the lowest number of uses of any key, see Victim
+	minUses int
+}
+
+// Value type of lfuPolicy.entries
+type lfuEntry struct {
+	key  string
+	uses int
+}
+
+// NewLFUPolicy returns an EvictionPolicy that evicts the least frequently
+// used key, and among those, the least recently used one.
+// All operations take amortised constant time.
+func NewLFUPolicy() EvictionPolicy {
+	return &lfuPolicy{
+		buckets: make(map[int]*clist.List),
+		entries: make(map[string]*clist.Element),
+	}
+}
+
+// NewLFU returns an initialized empty cache that evicts the least frequently
+// used entry, and among those, the least recently used one. Add and Get count
+// as uses. It is shorthand for NewWithPolicy with NewLFUPolicy.
+func NewLFU(size int, opts ...Option) (*LRU, error) {
+	return NewWithPolicy(size, NewLFUPolicy(), nil, opts...)
+}
+
+func (p *lfuPolicy) Record(key string) {
+	entry := &lfuEntry{key: key}
+	if e, ok := p.entries[key]; ok {
+		entry = p.unlink(e)
+	} else {
+		p.minUses = 1
+	}
+	entry.uses++
+
+	b, ok := p.buckets[entry.uses]
+	if !ok {
+		b = clist.New()
+		p.buckets[entry.uses] = b
+	}
+	p.entries[key] = b.PushFront(entry)
+}
+
+func (p *lfuPolicy) Victim() (string, bool) {
+	if len(p.entries) == 0 {
+		return "", false
+	}
+	// Buckets are deleted when they become empty, so skip the missing ones
+	for p.buckets[p.minUses] == nil {
+		p.minUses++
+	}
+	return p.buckets[p.minUses].Back().Value.(*lfuEntry).key, true
+}
+
+func (p *lfuPolicy) Remove(key string) {
+	if e, ok := p.entries[key]; ok {
+		p.unlink(e)
+		delete(p.entries, key)
+	}
+}
+
+// unlink removes e from its bucket, deleting the bucket if it becomes empty.
+func (p *lfuPolicy) unlink(e *clist.Element) *lfuEntry {
+	entry := e.Value.(*lfuEntry)
+	b := p.buckets[entry.uses]
+	b.Remove(e)
+	if b.Len() == 0 {
+		delete(p.buckets, entry.uses)
+	}
+	return entry
+}
@@ -0,0 +1,94 @@
+package lru
+
+import "testing"
+
+// Add a and b, use a more often but b more recently, then add c to a cache
+// of size 2, returning the key that is evicted for it
+func evictWithPolicy(t *testing.T, policy EvictionPolicy) string {
+	l, err := NewWithPolicy(2, policy, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.Get("a")
+	l.Get("a")
+	l.Get("b")
+	key, _, evicted := l.AddReturningEvicted("c", 3)
+	if !evicted {
+		t.Fatalf("should have an eviction")
+	}
+	if l.Contains(key) || !l.Contains("c") || l.Len() != 2 {
+		t.Errorf("bad cache contents after evicting %v", key)
+	}
+	return key
+}
+
+func TestLRUPolicy(t *testing.T) {
+	if key := evictWithPolicy(t, NewLRUPolicy()); key != "a" {
+		t.Errorf("LRU policy evicted %v, want a", key)
+	}
+}
+
+func TestLFUPolicy(t *testing.T) {
+	if key := evictWithPolicy(t, NewLFUPolicy()); key != "b" {
+		t.Errorf("LFU policy evicted %v, want b", key)
+	}
+
+	// Ties are broken by recency, and removed keys are skipped
+	p := NewLFUPolicy()
+	p.Record("x")
+	p.Record("y")
+	p.Record("z")
+	p.Record("x")
+	if key, ok := p.Victim(); !ok || key != "y" {
+		t.Errorf("Victim() = %v, %v, want y, true", key, ok)
+	}
+	p.Remove("y")
+	p.Remove("z")
+	if key, ok := p.Victim(); !ok || key != "x" {
+		t.Errorf("Victim() = %v, %v, want x, true", key, ok)
+	}
+	p.Remove("x")
+	if _, ok := p.Victim(); ok {
+		t.Errorf("Victim() of empty policy = true, want false")
+	}
+}
+
+func TestNewLFU(t *testing.T) {
+	l, err := NewLFU(3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer closeChecked(t, l)
+
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.Add("c", 3)
+	l.Get("a")
+	l.Get("a")
+	l.Get("b")
+	l.Get("c")
+	l.Add("b", 4) // Also a use
+
+	// c is the least frequently used, although a is the least recently used
+	if key, _, evicted := l.AddReturningEvicted("d", 5); !evicted || key != "c" {
+		t.Errorf("AddReturningEvicted(d) evicted %v, %v, want c, true", key, evicted)
+	}
+	// The new entry is the only one used once, so it goes next
+	if key, _, evicted := l.AddReturningEvicted("e", 6); !evicted || key != "d" {
+		t.Errorf("AddReturningEvicted(e) evicted %v, %v, want d, true", key, evicted)
+	}
+
+	if !l.Remove("a") || l.Remove("a") {
+		t.Errorf("Remove(a) should find a once")
+	}
+	if l.Len() != 2 || l.Contains("a") {
+		t.Errorf("a should be removed, len %v", l.Len())
+	}
+	if v, ok := l.Get("b"); !ok || v != 4 {
+		t.Errorf("Get(b) = %v, %v, want 4, true", v, ok)
+	}
+}
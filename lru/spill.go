@@ -0,0 +1,32 @@
+package lru
+
+import "sync/atomic"
+
+// WithSpillHandler hands the entries that are evicted to make room, including
+// after SetCapacity, to spill before they are dropped, e.g. to write them to
+// secondary storage. It is called in addition to the eviction callback, and
+// before it. Entries evicted by Close or removed through Remove are not
+// spilled. Errors returned by spill are counted in Metrics.SpillErrors, and
+// panics are handled like those of the eviction callback. spill must not
+// access the cache.
+func WithSpillHandler(spill func(key string, value interface{}) error) Option {
+	return func(c *LRU) {
+		c.spill = spill
+	}
+}
+
+// spillEvicted hands an item that was evicted to make room to the spill
+// handler, if any. It is called before the eviction callback.
+func (c *LRU) spillEvicted(evicted *item) {
+	if c.spill == nil || c.closed() {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil && c.onEvictPanic != nil {
+			c.onEvictPanic(evicted.key, r)
+		}
+	}()
+	if err := c.spill(evicted.key, evicted.value); err != nil {
+		atomic.AddInt64(&c.stats.spillErrors, 1)
+	}
+}
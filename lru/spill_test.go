@@ -0,0 +1,54 @@
+package lru
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLRUSpillHandler(t *testing.T) {
+	spilled := make(map[string]interface{})
+	spill := func(key string, value interface{}) error {
+		if key == "d" {
+			return errors.New("storage full")
+		}
+		spilled[key] = value
+		return nil
+	}
+	var evicted []interface{}
+	onEvicted := func(k interface{}, v interface{}) {
+		evicted = append(evicted, k)
+	}
+	l, err := NewWithEvict(2, onEvicted, WithSpillHandler(spill))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.DumpOrder() // Let the insertions settle, so a is the oldest
+	l.AddReturningEvicted("c", 3)
+	if len(spilled) != 1 || spilled["a"] != 1 {
+		t.Errorf("spilled %v, want a", spilled)
+	}
+	if len(evicted) != 1 || evicted[0] != "a" {
+		t.Errorf("eviction callbacks for %v, want a", evicted)
+	}
+
+	// Errors are counted, and the entry is dropped anyway
+	l.AddReturningEvicted("d", 4)
+	l.AddReturningEvicted("e", 5)
+	l.AddReturningEvicted("f", 6)
+	if n := l.Collect().SpillErrors; n != 1 {
+		t.Errorf("SpillErrors = %d, want 1", n)
+	}
+	if l.Len() != 2 || !l.Contains("e") || !l.Contains("f") {
+		t.Errorf("e and f should be cached, len %v", l.Len())
+	}
+
+	// Entries that are removed or evicted by Close are not spilled
+	l.Remove("e")
+	closeChecked(t, l)
+	if len(spilled) != 3 || spilled["b"] != 2 || spilled["c"] != 3 {
+		t.Errorf("spilled %v, want a, b and c", spilled)
+	}
+}
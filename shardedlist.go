@@ -0,0 +1,100 @@
+// Set of independent Lists, for insertion-heavy workloads that don't need a
+// global order of the values.
+
+package concurrent
+
+import "sync/atomic"
+
+// ShardedList spreads its values over several independent Lists, so that
+// concurrent insertions into different shards don't contend on the same
+// sentinels. There is no order between values in different shards.
+type ShardedList struct {
+	shards []List
+
+	// Chooses the shard of a value, round robin over next if nil
+	hash func(v interface{}) uint32
+	next uint32
+}
+
+// NewShardedList returns a ShardedList of n shards, or of one if n is not
+// positive. Values are placed in the shard given by hash modulo n, so hash
+// can keep related values together, e.g. by hashing a key stored in them.
+// If hash is nil, values are distributed round robin instead.
+func NewShardedList(n int, hash func(v interface{}) uint32) *ShardedList {
+	if n < 1 {
+		n = 1
+	}
+	return &ShardedList{
+		shards: make([]List, n),
+		hash:   hash,
+	}
+}
+
+// shard returns the shard that value v is inserted into.
+func (s *ShardedList) shard(v interface{}) *List {
+	var i uint32
+	if s.hash != nil {
+		i = s.hash(v)
+	} else {
+		i = atomic.AddUint32(&s.next, 1)
+	}
+	return &s.shards[i%uint32(len(s.shards))]
+}
+
+// Len returns the number of elements in all shards of s.
+// The shards are counted one by one, so concurrent changes may be counted
+// for some shards only.
+func (s *ShardedList) Len() int {
+	n := 0
+	for i := range s.shards {
+		n += s.shards[i].Len()
+	}
+	return n
+}
+
+// Shards returns the number of shards of s.
+func (s *ShardedList) Shards() int { return len(s.shards) }
+
+// Shard returns shard i of s, to use operations of List on it.
+func (s *ShardedList) Shard(i int) *List { return &s.shards[i] }
+
+// PushFront inserts a new element e with value v at the front of its shard
+// and returns e.
+func (s *ShardedList) PushFront(v interface{}) *Element {
+	return s.shard(v).PushFront(v)
+}
+
+// PushBack inserts a new element e with value v at the back of its shard
+// and returns e.
+func (s *ShardedList) PushBack(v interface{}) *Element {
+	return s.shard(v).PushBack(v)
+}
+
+// Remove removes e from s if e is an element of any of its shards.
+// It returns the element value e.Value, like List.Remove also if e was not
+// removed.
+// The element must not be nil.
+func (s *ShardedList) Remove(e *Element) interface{} {
+	for i := range s.shards {
+		if s.shards[i].Contains(e) {
+			return s.shards[i].Remove(e)
+		}
+	}
+	return e.loadValue()
+}
+
+// ForEach calls f for the elements of s, shard by shard, each from front to
+// back, until f returns false. Each shard is walked like in ForEachIndexed
+// of List, so f may access s.
+func (s *ShardedList) ForEach(f func(e *Element) bool) {
+	for i := range s.shards {
+		more := true
+		s.shards[i].ForEachIndexed(func(_ int, e *Element) bool {
+			more = f(e)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package concurrent
+
+import (
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestShardedList(t *testing.T) {
+	s := NewShardedList(4, func(v interface{}) uint32 { return uint32(v.(int)) })
+	es := make([]*Element, 10)
+	for i := range es {
+		if i%2 == 0 {
+			es[i] = s.PushBack(i)
+		} else {
+			es[i] = s.PushFront(i)
+		}
+	}
+	if n := s.Len(); n != 10 {
+		t.Errorf("Len() = %d, want 10", n)
+	}
+	for i := 0; i < s.Shards(); i++ {
+		for e := s.Shard(i).Front(); e != nil; e = e.Next() {
+			if e.Value.(int)%s.Shards() != i {
+				t.Errorf("value %v in shard %d", e.Value, i)
+			}
+		}
+	}
+
+	if v := s.Remove(es[3]); v != 3 {
+		t.Errorf("Remove(es[3]) = %v, want 3", v)
+	}
+	if v := s.Remove(es[3]); v != 3 {
+		t.Errorf("second Remove(es[3]) = %v, want 3", v)
+	}
+	var values []int
+	s.ForEach(func(e *Element) bool {
+		values = append(values, e.Value.(int))
+		return true
+	})
+	sort.Ints(values)
+	if len(values) != 9 || values[3] != 4 {
+		t.Errorf("ForEach saw %v, want 0 to 9 without 3", values)
+	}
+
+	// Early termination
+	n := 0
+	s.ForEach(func(e *Element) bool {
+		n++
+		return n < 5
+	})
+	if n != 5 {
+		t.Errorf("ForEach stopping after 5 elements called f %d times", n)
+	}
+}
+
+func TestShardedListRoundRobin(t *testing.T) {
+	s := NewShardedList(4, nil)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				s.PushBack(i)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := s.Len(); n != 400 {
+		t.Errorf("Len() = %d, want 400", n)
+	}
+	for i := 0; i < s.Shards(); i++ {
+		if n := s.Shard(i).Len(); n != 100 {
+			t.Errorf("shard %d holds %d values, want 100", i, n)
+		}
+	}
+	if n := NewShardedList(0, nil).Shards(); n != 1 {
+		t.Errorf("NewShardedList(0) has %d shards, want 1", n)
+	}
+}
+
+func BenchmarkListPushBackParallel(b *testing.B) {
+	l := New()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.PushBack(nil)
+		}
+	})
+}
+
+func BenchmarkShardedListPushBackParallel(b *testing.B) {
+	s := NewShardedList(16, nil)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.PushBack(nil)
+		}
+	})
+}
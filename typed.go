@@ -0,0 +1,27 @@
+//go:build go1.18
+// +build go1.18
+
+package concurrent
+
+// ValueOf returns the value of e as a T, and false if e is nil or its value
+// is not a T, which spares callers the type assertion.
+func ValueOf[T any](e *Element) (T, bool) {
+	var zero T
+	if e == nil {
+		return zero, false
+	}
+	v, ok := e.loadValue().(T)
+	return v, ok
+}
+
+// FrontValueOf returns the value of the first element of l as a T, and false
+// if l is empty or the value is not a T. See PeekFront.
+func FrontValueOf[T any](l *List) (T, bool) {
+	var zero T
+	v, ok := l.PeekFront()
+	if !ok {
+		return zero, false
+	}
+	t, ok := v.(T)
+	return t, ok
+}
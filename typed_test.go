@@ -0,0 +1,43 @@
+//go:build go1.18
+// +build go1.18
+
+package concurrent
+
+import "testing"
+
+func TestValueOf(t *testing.T) {
+	l := New()
+	e := l.PushBack(1)
+	s := l.PushBack("two")
+
+	if v, ok := ValueOf[int](e); !ok || v != 1 {
+		t.Errorf("ValueOf[int](e) = %v, %v, want 1, true", v, ok)
+	}
+	if v, ok := ValueOf[string](s); !ok || v != "two" {
+		t.Errorf("ValueOf[string](s) = %q, %v, want two, true", v, ok)
+	}
+	if v, ok := ValueOf[string](e); ok || v != "" {
+		t.Errorf("ValueOf[string](e) = %q, %v, want empty, false", v, ok)
+	}
+	if v, ok := ValueOf[int](nil); ok || v != 0 {
+		t.Errorf("ValueOf[int](nil) = %v, %v, want 0, false", v, ok)
+	}
+}
+
+func TestFrontValueOf(t *testing.T) {
+	l := New()
+	if v, ok := FrontValueOf[int](l); ok || v != 0 {
+		t.Errorf("FrontValueOf[int] of empty list = %v, %v, want 0, false", v, ok)
+	}
+	l.PushBack(1)
+	if v, ok := FrontValueOf[int](l); !ok || v != 1 {
+		t.Errorf("FrontValueOf[int] = %v, %v, want 1, true", v, ok)
+	}
+	if v, ok := FrontValueOf[*List](l); ok || v != nil {
+		t.Errorf("FrontValueOf[*List] = %v, %v, want nil, false", v, ok)
+	}
+	// Interface types match any value implementing them
+	if v, ok := FrontValueOf[interface{}](l); !ok || v != 1 {
+		t.Errorf("FrontValueOf[interface{}] = %v, %v, want 1, true", v, ok)
+	}
+}